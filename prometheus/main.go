@@ -7,6 +7,9 @@ import (
 	"net/http"
 	"time"
 
+	"my-go-app/redis/cahce/ratelimit"
+
+	"github.com/go-redis/redis/v8"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -39,10 +42,22 @@ func main() {
 	// Запускаем горутину для обновления метрик
 	go updateMetrics(ctx)
 
+	// Ограничение запросов по IP, без redis запросы пропускаются
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	limiter, err := ratelimit.New(rdb, ratelimit.Config{
+		Algorithm: ratelimit.SlidingWindow,
+		Prefix:    "metrics-demo:rate",
+		Default:   ratelimit.PerMinute(600),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	limit := ratelimit.Middleware(limiter, ratelimit.MiddlewareConfig{Failure: ratelimit.FailOpen})
+
 	// HTTP обработчики
-	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/api/data", dataHandler)
-	http.HandleFunc("/api/error", errorHandler)
+	http.Handle("/", limit(http.HandlerFunc(homeHandler)))
+	http.Handle("/api/data", limit(http.HandlerFunc(dataHandler)))
+	http.Handle("/api/error", limit(http.HandlerFunc(errorHandler)))
 
 	// Метрики Prometheus
 	http.Handle("/metrics", promhttp.Handler())
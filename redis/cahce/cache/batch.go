@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"my-go-app/redis/cahce/metrics"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// BatchLoadFunc fetches the values of keys from the origin in one go.
+// Keys missing from the result do not exist at the origin.
+type BatchLoadFunc[T any] func(ctx context.Context, keys []string) (map[string]T, error)
+
+// GetMany looks keys up in the local tier and then in one redis pipeline.
+// It returns an entry and an error for every key, in order; the error is
+// ErrMiss or ErrNotFound for keys without a cached value.
+func (c *Cache[T]) GetMany(ctx context.Context, keys []string) ([]Entry[T], []error) {
+	ctx = c.labelled(ctx)
+
+	entries := make([]Entry[T], len(keys))
+	errs := make([]error, len(keys))
+
+	var pending []int
+	for i, key := range keys {
+		if c.local != nil {
+			if e, ok := c.local.get(key); ok {
+				c.stats.localHits.Add(1)
+				e.State = stateAt(e.FreshUntil)
+				observeState(ctx, e.State)
+				entries[i] = e
+				continue
+			}
+			c.stats.localMisses.Add(1)
+		}
+		pending = append(pending, i)
+	}
+	if len(pending) == 0 {
+		return entries, errs
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, len(pending))
+	// a failed pipeline sets its error on every command, checked below
+	c.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for j, i := range pending {
+			cmds[j] = p.HGetAll(ctx, keys[i])
+		}
+		return nil
+	})
+
+	for j, i := range pending {
+		fields, err := cmds[j].Result()
+		e := Entry[T]{State: Miss}
+		if err == nil {
+			e, err = c.decode(fields)
+		}
+		entries[i], errs[i] = c.record(ctx, keys[i], e, err)
+	}
+	return entries, errs
+}
+
+// SetMany stores every value in values under its key in one round trip.
+func (c *Cache[T]) SetMany(ctx context.Context, values map[string]T, ttl time.Duration) error {
+	ctx = c.labelled(ctx)
+
+	fields := make(map[string]map[string]interface{}, len(values))
+	entries := make(map[string]Entry[T], len(values))
+	for key, v := range values {
+		f, e, err := c.encode(ctx, v, ttl)
+		if err != nil {
+			return err
+		}
+		fields[key], entries[key] = f, e
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// scripts in a pipeline are sent whole, as EVALSHA could not fall
+	// back to EVAL there
+	cmds := make(map[string]*redis.Cmd, len(fields))
+	_, err := c.db.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for key, f := range fields {
+			args, err := c.writeArgs(f, entries[key].TTL, nil)
+			if err != nil {
+				return err
+			}
+			cmds[key] = writeScript.Eval(ctx, p, []string{key}, args...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]tagged)
+	for key, cmd := range cmds {
+		res, err := cmd.Slice()
+		if err != nil {
+			return err
+		}
+		pttl, tags, err := parseTagged(res)
+		if err != nil {
+			return err
+		}
+		if len(tags) > 0 {
+			keys[key] = tagged{tags: tags, ttl: pttl}
+		}
+	}
+	if err := c.tag(ctx, keys); err != nil {
+		return err
+	}
+
+	for key, e := range entries {
+		c.setLocal(key, e)
+	}
+	return nil
+}
+
+// FetchMany is Fetch for several keys. Cached entries are read with
+// GetMany, the keys that missed or failed are passed to a single load
+// call and its results are written back. Keys the load does not return
+// are reported as ErrNotFound and remembered for NegativeTTL. Stale
+// entries are returned as they are; refreshing them is up to the caller.
+func (c *Cache[T]) FetchMany(ctx context.Context, keys []string, ttl time.Duration, load BatchLoadFunc[T]) ([]Entry[T], []error) {
+	entries, errs := c.GetMany(ctx, keys)
+
+	seen := make(map[string]bool)
+	var missing []string
+	for i, err := range errs {
+		if err == nil || errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if !errors.Is(err, ErrMiss) {
+			log.Printf("cache: get %s: %v", keys[i], err)
+		}
+		if !seen[keys[i]] {
+			seen[keys[i]] = true
+			missing = append(missing, keys[i])
+		}
+	}
+	if len(missing) == 0 {
+		return entries, errs
+	}
+
+	loadCtx := c.labelled(ctx)
+	if c.opts.LoadTimeout > 0 {
+		var cancel context.CancelFunc
+		loadCtx, cancel = context.WithTimeout(loadCtx, c.opts.LoadTimeout)
+		defer cancel()
+	}
+
+	values, err := c.loadMany(loadCtx, missing, load)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = ErrLoadTimeout
+	}
+	if err != nil {
+		for i := range keys {
+			if seen[keys[i]] {
+				errs[i] = err
+			}
+		}
+		return entries, errs
+	}
+
+	var absent []string
+	for _, key := range missing {
+		if _, ok := values[key]; !ok {
+			absent = append(absent, key)
+		}
+	}
+	if err := c.SetMany(loadCtx, values, ttl); err != nil {
+		log.Printf("cache: set %d keys: %v", len(values), err)
+	}
+	if err := c.SetAbsent(loadCtx, absent...); err != nil {
+		log.Printf("cache: set absent %d keys: %v", len(absent), err)
+	}
+
+	now := time.Now()
+	for i, key := range keys {
+		if !seen[key] {
+			continue
+		}
+		v, ok := values[key]
+		if !ok {
+			entries[i], errs[i] = Entry[T]{State: Miss}, ErrNotFound
+			continue
+		}
+		entries[i] = Entry[T]{Value: v, State: Miss, StoredAt: now}
+		if ttl > 0 {
+			entries[i].FreshUntil, entries[i].TTL = now.Add(ttl), ttl
+		}
+		errs[i] = nil
+	}
+	return entries, errs
+}
+
+func (c *Cache[T]) loadMany(ctx context.Context, keys []string, load BatchLoadFunc[T]) (map[string]T, error) {
+	start := time.Now()
+	values, err := load(ctx, keys)
+	metrics.ObserveLoad(ctx, time.Since(start))
+	return values, err
+}
@@ -0,0 +1,456 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"my-go-app/redis/cahce/metrics"
+	"my-go-app/redis/cahce/storage"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// freshField and storedField hold the unix millisecond times after which
+// an entry is stale and at which it was written, ttlField the ttl in
+// milliseconds it was written with. They live next to the value's own
+// fields in the hash.
+const (
+	freshField  = "__fresh"
+	storedField = "__at"
+	ttlField    = "__ttl"
+)
+
+const (
+	Miss  State = "miss"
+	Fresh State = "fresh"
+	Stale State = "stale"
+)
+
+var (
+	ErrMiss = errors.New("cache: miss")
+	// ErrNotFound marks values missing at the origin. Loaders return an
+	// error wrapping it to have the absence cached for NegativeTTL.
+	ErrNotFound = errors.New("cache: not found")
+)
+
+type (
+	// Cache is a cache-aside layer that stores values of T as redis hashes,
+	// either field by field or as one encoded blob depending on the Codec.
+	Cache[T any] struct {
+		db    redis.UniversalClient
+		opts  Options
+		codec Codec
+		group singleflight.Group
+		local *lru[T]
+		lock  *storage.Locker
+		stats counters
+	}
+
+	Options struct {
+		// Name labels the cache's metrics, usually its key namespace.
+		Name string
+		// LoadTimeout bounds how long a caller waits for a load, including
+		// one started by another caller. Zero waits as long as ctx allows.
+		LoadTimeout time.Duration
+		// LockTTL enables cross-instance coalescing: a loader holds a redis
+		// lock on the key for at most LockTTL.
+		LockTTL time.Duration
+		// LockPoll is how often instances waiting on the lock re-check the
+		// cache. Defaults to 50ms.
+		LockPoll time.Duration
+		// Stale is how long an entry is kept past its ttl. During that
+		// window it is served as Stale and refreshed in the background.
+		Stale time.Duration
+		// Channel is the pub/sub channel used to broadcast invalidations
+		// between instances. Empty disables broadcasting.
+		Channel string
+		// LocalSize enables an in-process LRU tier holding up to LocalSize
+		// entries for at most LocalTTL in front of redis. Other instances'
+		// writes become visible once the local entry is dropped through
+		// Channel or expires.
+		LocalSize int
+		LocalTTL  time.Duration
+		// Codec encodes stored values, Hash by default. Entries written
+		// with another built-in codec remain readable.
+		Codec Codec
+		// Version is the schema version written into every entry. Entries
+		// of any other version are treated as misses and reloaded.
+		Version uint32
+		// NegativeTTL is how long ErrNotFound from a loader is remembered.
+		// Zero disables negative caching.
+		NegativeTTL time.Duration
+		// TagPrefix prefixes the sets that map tags to keys. Defaults to
+		// Name.
+		TagPrefix string
+		// Jitter adds a random share of itself to the ttl of every entry
+		// written, so entries written together do not expire together.
+		Jitter time.Duration
+		// Sliding keeps entries that are read fresh: once less than half
+		// of its ttl is left, a fresh entry read from redis gets a full
+		// ttl again.
+		Sliding bool
+	}
+
+	// State tells whether a value came from a fresh entry, a stale one
+	// or the origin.
+	State string
+
+	// Entry is a value together with its cache metadata. FreshUntil and
+	// TTL are zero for entries that never go stale.
+	Entry[T any] struct {
+		Value      T
+		State      State
+		StoredAt   time.Time
+		FreshUntil time.Time
+		TTL        time.Duration
+
+		tags []string
+	}
+
+	// LoadFunc fetches a value from the origin on a cache miss.
+	LoadFunc[T any] func(ctx context.Context) (T, error)
+)
+
+func New[T any](db redis.UniversalClient, opts Options) *Cache[T] {
+	c := &Cache[T]{db: db, opts: opts, codec: opts.Codec}
+	if c.codec == nil {
+		c.codec = Hash
+	}
+	if opts.LocalSize > 0 && opts.LocalTTL > 0 {
+		c.local = newLRU[T](opts.LocalSize, opts.LocalTTL)
+	}
+	if opts.LockTTL > 0 {
+		c.lock = storage.NewLocker(db, storage.LockConfig{TTL: opts.LockTTL})
+	}
+	return c
+}
+
+// Get returns the cached value for key, fresh or stale, or ErrMiss.
+func (c *Cache[T]) Get(ctx context.Context, key string) (T, error) {
+	v, _, err := c.Lookup(ctx, key)
+	return v, err
+}
+
+// Lookup is Get that also reports whether the entry is Fresh or Stale.
+func (c *Cache[T]) Lookup(ctx context.Context, key string) (T, State, error) {
+	e, err := c.LookupEntry(ctx, key)
+	return e.Value, e.State, err
+}
+
+// LookupEntry is Lookup returning the entry's metadata as well.
+func (c *Cache[T]) LookupEntry(ctx context.Context, key string) (Entry[T], error) {
+	ctx = c.labelled(ctx)
+
+	if c.local != nil {
+		if e, ok := c.local.get(key); ok {
+			c.stats.localHits.Add(1)
+			e.State = stateAt(e.FreshUntil)
+			observeState(ctx, e.State)
+			return e, nil
+		}
+		c.stats.localMisses.Add(1)
+	}
+
+	e, err := c.lookupRedis(ctx, key)
+	return c.record(ctx, key, e, err)
+}
+
+// record accounts for a redis lookup and fills the local tier on a hit.
+func (c *Cache[T]) record(ctx context.Context, key string, e Entry[T], err error) (Entry[T], error) {
+	if errors.Is(err, ErrMiss) {
+		c.stats.redisMisses.Add(1)
+		metrics.ObserveLookup(ctx, metrics.Miss)
+		return e, err
+	}
+	if errors.Is(err, ErrNotFound) {
+		c.stats.redisHits.Add(1)
+		metrics.ObserveLookup(ctx, metrics.Negative)
+		return e, err
+	}
+	if err != nil {
+		metrics.ObserveLookup(ctx, metrics.Error)
+		return e, err
+	}
+	c.stats.redisHits.Add(1)
+
+	if c.opts.Sliding {
+		c.slide(ctx, key, &e)
+	}
+	c.setLocal(key, e)
+	e.State = stateAt(e.FreshUntil)
+	observeState(ctx, e.State)
+	return e, nil
+}
+
+func (c *Cache[T]) lookupRedis(ctx context.Context, key string) (Entry[T], error) {
+	fields, err := c.db.HGetAll(ctx, key).Result()
+	if err != nil {
+		return Entry[T]{State: Miss}, err
+	}
+	return c.decode(fields)
+}
+
+// decode turns the fields of a stored hash back into an entry.
+func (c *Cache[T]) decode(fields map[string]string) (Entry[T], error) {
+	e := Entry[T]{State: Miss}
+	if len(fields) == 0 {
+		return e, ErrMiss
+	}
+	if _, ok := fields[tagsField]; ok && len(fields) == 1 {
+		// only tags waiting for a value, see Tag
+		return e, ErrMiss
+	}
+
+	id, version, err := decodeHeader(fields)
+	if err != nil {
+		return e, err
+	}
+	if version != c.opts.Version {
+		return e, ErrMiss
+	}
+	if _, ok := fields[absentField]; ok {
+		return e, ErrNotFound
+	}
+
+	codec, err := c.codecFor(id)
+	if err != nil {
+		return e, err
+	}
+	if err := codec.Decode(fields, &e.Value); err != nil {
+		return e, err
+	}
+
+	if ms, err := strconv.ParseInt(fields[freshField], 10, 64); err == nil {
+		e.FreshUntil = time.UnixMilli(ms)
+	}
+	if ms, err := strconv.ParseInt(fields[storedField], 10, 64); err == nil {
+		e.StoredAt = time.UnixMilli(ms)
+	}
+	if ms, err := strconv.ParseInt(fields[ttlField], 10, 64); err == nil {
+		e.TTL = time.Duration(ms) * time.Millisecond
+	}
+	if list := fields[tagsField]; list != "" {
+		if err := json.Unmarshal([]byte(list), &e.tags); err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+func (c *Cache[T]) setLocal(key string, e Entry[T]) {
+	if c.local == nil {
+		return
+	}
+
+	var expires time.Time
+	if !e.FreshUntil.IsZero() {
+		expires = e.FreshUntil.Add(c.opts.Stale)
+	}
+	c.local.set(key, e, expires)
+}
+
+func (c *Cache[T]) labelled(ctx context.Context) context.Context {
+	return metrics.WithNamespace(ctx, c.opts.Name)
+}
+
+func observeState(ctx context.Context, state State) {
+	if state == Stale {
+		metrics.ObserveLookup(ctx, metrics.Stale)
+		return
+	}
+	metrics.ObserveLookup(ctx, metrics.Hit)
+}
+
+func stateAt(freshUntil time.Time) State {
+	if !freshUntil.IsZero() && time.Now().After(freshUntil) {
+		return Stale
+	}
+	return Fresh
+}
+
+// Set replaces the value stored under key. The entry is fresh for ttl and
+// then stale for Options.Stale; a zero ttl keeps it until it is deleted.
+func (c *Cache[T]) Set(ctx context.Context, key string, v T, ttl time.Duration) error {
+	return c.SetTagged(ctx, key, v, ttl)
+}
+
+// SetTagged is Set that also adds key to every tag in tags, so that it is
+// removed by InvalidateTag for any of them.
+func (c *Cache[T]) SetTagged(ctx context.Context, key string, v T, ttl time.Duration, tags ...string) error {
+	ctx = c.labelled(ctx)
+
+	fields, e, err := c.encode(ctx, v, ttl)
+	if err != nil {
+		return err
+	}
+
+	args, err := c.writeArgs(fields, e.TTL, tags)
+	if err != nil {
+		return err
+	}
+	res, err := writeScript.Run(ctx, c.db, []string{key}, args...).Slice()
+	if err != nil {
+		return err
+	}
+	pttl, all, err := parseTagged(res)
+	if err != nil {
+		return err
+	}
+	if err := c.tag(ctx, map[string]tagged{key: {tags: all, ttl: pttl}}); err != nil {
+		return err
+	}
+
+	c.setLocal(key, e)
+	return nil
+}
+
+// encode builds the hash fields stored for v along with the entry they
+// describe.
+func (c *Cache[T]) encode(ctx context.Context, v T, ttl time.Duration) (map[string]interface{}, Entry[T], error) {
+	fields, err := c.codec.Encode(v)
+	if err != nil {
+		return nil, Entry[T]{}, err
+	}
+	fields[headerField] = encodeHeader(c.codec.ID(), c.opts.Version)
+	metrics.ObserveEntrySize(ctx, entrySize(fields))
+
+	e := Entry[T]{Value: v, State: Fresh, StoredAt: time.Now()}
+	fields[storedField] = e.StoredAt.UnixMilli()
+	if ttl > 0 && c.opts.Jitter > 0 {
+		ttl += rand.N(c.opts.Jitter)
+	}
+	if ttl > 0 {
+		e.FreshUntil, e.TTL = e.StoredAt.Add(ttl), ttl
+		fields[freshField] = e.FreshUntil.UnixMilli()
+		fields[ttlField] = ttl.Milliseconds()
+	}
+	return fields, e, nil
+}
+
+// writeArgs are the ARGV of writeScript storing fields for ttl, which is
+// zero for entries that never expire, with tags added to the stored ones.
+func (c *Cache[T]) writeArgs(fields map[string]interface{}, ttl time.Duration, tags []string) ([]interface{}, error) {
+	var expire int64
+	if ttl > 0 {
+		expire = (ttl + c.opts.Stale).Milliseconds()
+	}
+	list := []byte("[]")
+	if len(tags) > 0 {
+		var err error
+		if list, err = json.Marshal(tags); err != nil {
+			return nil, err
+		}
+	}
+
+	args := make([]interface{}, 0, 2+2*len(fields))
+	args = append(args, expire, list)
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	return args, nil
+}
+
+func (c *Cache[T]) Delete(ctx context.Context, keys ...string) error {
+	ctx = c.labelled(ctx)
+
+	if c.local != nil {
+		for _, key := range keys {
+			c.local.remove(key)
+		}
+	}
+	_, err := storage.DeleteKeys(ctx, c.db, keys...)
+	return err
+}
+
+// GetOrLoad returns the cached value for key, calling load and storing its
+// result on a miss. Concurrent misses for the same key share one load;
+// failing to write the cache does not fail the call.
+func (c *Cache[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error) {
+	e, err := c.Fetch(ctx, key, ttl, load)
+	return e.Value, err
+}
+
+// Fetch is GetOrLoad returning the entry's metadata as well. Stale
+// entries are returned immediately and refreshed in the background.
+func (c *Cache[T]) Fetch(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (Entry[T], error) {
+	e, err := c.LookupEntry(ctx, key)
+	if err == nil {
+		if e.State == Stale {
+			c.Refresh(ctx, key, ttl, load)
+		}
+		return e, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		return e, err
+	}
+	if !errors.Is(err, ErrMiss) {
+		log.Printf("cache: get %s: %v", key, err)
+	}
+
+	ch := c.start(ctx, key, ttl, load)
+
+	var timeout <-chan time.Time
+	if c.opts.LoadTimeout > 0 {
+		timer := time.NewTimer(c.opts.LoadTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return e, res.Err
+		}
+		e = Entry[T]{Value: res.Val.(T), State: Miss, StoredAt: time.Now()}
+		if ttl > 0 {
+			e.FreshUntil, e.TTL = e.StoredAt.Add(ttl), ttl
+		}
+		return e, nil
+	case <-timeout:
+		return e, ErrLoadTimeout
+	case <-ctx.Done():
+		return e, ctx.Err()
+	}
+}
+
+// Refresh reloads key in the background unless a load is already running.
+func (c *Cache[T]) Refresh(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) {
+	c.start(ctx, key, ttl, load)
+}
+
+func (c *Cache[T]) start(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) <-chan singleflight.Result {
+	ctx = c.labelled(ctx)
+	return c.group.DoChan(key, func() (interface{}, error) {
+		// the shared load must not depend on whichever caller started it
+		loadCtx := context.WithoutCancel(ctx)
+		if c.opts.LoadTimeout > 0 {
+			var cancel context.CancelFunc
+			loadCtx, cancel = context.WithTimeout(loadCtx, c.opts.LoadTimeout)
+			defer cancel()
+		}
+		return c.load(loadCtx, key, ttl, load)
+	})
+}
+
+func entrySize(fields map[string]interface{}) int {
+	size := 0
+	for k, v := range fields {
+		size += len(k)
+		switch v := v.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		default:
+			size += len(fmt.Sprint(v))
+		}
+	}
+	return size
+}
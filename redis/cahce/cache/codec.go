@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// headerField carries the entry header: a magic byte, the codec id
+	// and the schema version as a uvarint.
+	headerField = "__hdr"
+	// dataField holds the value for codecs that store it as one blob.
+	dataField = "__data"
+
+	headerMagic byte = 0xca
+)
+
+const (
+	hashID byte = iota + 1
+	jsonID
+	msgpackID
+	gobID
+	protoID
+)
+
+var (
+	ErrBadHeader = errors.New("cache: malformed entry header")
+	ErrNotProto  = errors.New("cache: value is not a proto.Message")
+
+	Hash Codec = hashCodec{}
+
+	codecs = map[byte]func(Compression) Codec{
+		hashID:    func(Compression) Codec { return Hash },
+		jsonID:    NewJSON,
+		msgpackID: NewMsgPack,
+		gobID:     NewGob,
+		protoID:   NewProto,
+	}
+)
+
+type (
+	// Codec maps a value to the fields of its redis hash and back.
+	Codec interface {
+		ID() byte
+		Encode(v any) (map[string]interface{}, error)
+		Decode(fields map[string]string, dst any) error
+	}
+
+	hashCodec struct{}
+
+	// blobCodec stores the marshalled value in dataField, compressed when
+	// it is large enough.
+	blobCodec struct {
+		id          byte
+		compression Compression
+		marshal     func(v any) ([]byte, error)
+		unmarshal   func(data []byte, dst any) error
+	}
+)
+
+func (hashCodec) ID() byte { return hashID }
+
+func (hashCodec) Encode(v any) (map[string]interface{}, error) { return ToHash(v) }
+
+func (hashCodec) Decode(fields map[string]string, dst any) error { return FromHash(fields, dst) }
+
+func NewJSON(c Compression) Codec {
+	return &blobCodec{id: jsonID, compression: c, marshal: json.Marshal, unmarshal: json.Unmarshal}
+}
+
+func NewMsgPack(c Compression) Codec {
+	return &blobCodec{id: msgpackID, compression: c, marshal: msgpack.Marshal, unmarshal: msgpack.Unmarshal}
+}
+
+func NewGob(c Compression) Codec {
+	return &blobCodec{id: gobID, compression: c, marshal: gobMarshal, unmarshal: gobUnmarshal}
+}
+
+// NewProto encodes values whose type is a proto.Message, i.e. a Cache of
+// a generated message pointer type.
+func NewProto(c Compression) Codec {
+	return &blobCodec{id: protoID, compression: c, marshal: protoMarshal, unmarshal: protoUnmarshal}
+}
+
+func (c *blobCodec) ID() byte { return c.id }
+
+func (c *blobCodec) Encode(v any) (map[string]interface{}, error) {
+	data, err := c.marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = c.compression.compress(data)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{dataField: data}, nil
+}
+
+func (c *blobCodec) Decode(fields map[string]string, dst any) error {
+	data, err := decompress([]byte(fields[dataField]))
+	if err != nil {
+		return err
+	}
+	return c.unmarshal(data, dst)
+}
+
+func gobMarshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gobUnmarshal(data []byte, dst any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(dst)
+}
+
+func protoMarshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProto
+	}
+	return proto.Marshal(m)
+}
+
+// protoUnmarshal expects dst to point to a message pointer and allocates
+// the message when it is nil.
+func protoUnmarshal(data []byte, dst any) error {
+	ptr := reflect.ValueOf(dst)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Ptr {
+		return ErrNotProto
+	}
+	if ptr.Elem().IsNil() {
+		ptr.Elem().Set(reflect.New(ptr.Elem().Type().Elem()))
+	}
+
+	m, ok := ptr.Elem().Interface().(proto.Message)
+	if !ok {
+		return ErrNotProto
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func encodeHeader(codec byte, version uint32) string {
+	b := []byte{headerMagic, codec}
+	return string(binary.AppendUvarint(b, uint64(version)))
+}
+
+// decodeHeader reads the entry header. Entries written before headers
+// existed are hash encoded with schema version 0.
+func decodeHeader(fields map[string]string) (byte, uint32, error) {
+	h, ok := fields[headerField]
+	if !ok {
+		return hashID, 0, nil
+	}
+	if len(h) < 3 || h[0] != headerMagic {
+		return 0, 0, ErrBadHeader
+	}
+
+	version, n := binary.Uvarint([]byte(h[2:]))
+	if n <= 0 {
+		return 0, 0, ErrBadHeader
+	}
+	return h[1], uint32(version), nil
+}
+
+// codecFor returns the codec an entry was written with, so switching the
+// cache's codec does not break entries that are already stored.
+func (c *Cache[T]) codecFor(id byte) (Codec, error) {
+	if id == c.codec.ID() {
+		return c.codec, nil
+	}
+
+	newCodec, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("cache: unknown codec %d", id)
+	}
+	// compression is self-describing, the setting only matters for writes
+	return newCodec(Compression{}), nil
+}
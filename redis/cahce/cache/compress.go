@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	NoCompression Algorithm = iota
+	Gzip
+	Zstd
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+type (
+	Algorithm byte
+
+	// Compression compresses blob encoded values of at least Above bytes.
+	// Every blob starts with the Algorithm byte used, so entries can be
+	// read whatever the current setting is.
+	Compression struct {
+		Algorithm Algorithm
+		Above     int
+	}
+)
+
+func (c Compression) compress(data []byte) ([]byte, error) {
+	if c.Algorithm == NoCompression || len(data) < c.Above {
+		return append([]byte{byte(NoCompression)}, data...), nil
+	}
+
+	switch c.Algorithm {
+	case Gzip:
+		var buf bytes.Buffer
+		buf.WriteByte(byte(Gzip))
+
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(data, []byte{byte(Zstd)}), nil
+	}
+	return nil, fmt.Errorf("cache: unknown compression %d", c.Algorithm)
+}
+
+func decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("cache: empty value")
+	}
+
+	switch Algorithm(data[0]) {
+	case NoCompression:
+		return data[1:], nil
+	case Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(data[1:], nil)
+	}
+	return nil, fmt.Errorf("cache: unknown compression %d", data[0])
+}
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
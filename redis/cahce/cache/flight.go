@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"my-go-app/redis/cahce/metrics"
+	"my-go-app/redis/cahce/storage"
+)
+
+const defaultLockPoll = 50 * time.Millisecond
+
+var ErrLoadTimeout = errors.New("cache: timed out waiting for load")
+
+// load runs at most once per key per process. When a LockTTL is set it
+// also takes a redis lock so that only one instance hits the origin, while
+// the others poll the cache for the leader's result.
+func (c *Cache[T]) load(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error) {
+	if c.lock == nil {
+		return c.loadAndSet(ctx, key, ttl, load)
+	}
+
+	poll := c.opts.LockPoll
+	if poll <= 0 {
+		poll = defaultLockPoll
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		lock, err := c.lock.TryLock(ctx, key+":lock")
+		if err == nil {
+			defer lock.Release(context.WithoutCancel(ctx))
+			// the previous holder may have filled the key just now
+			e, err := c.lookupRedis(ctx, key)
+			if err == nil && stateAt(e.FreshUntil) == Fresh {
+				return e.Value, nil
+			}
+			if errors.Is(err, ErrNotFound) {
+				return e.Value, err
+			}
+			return c.loadAndSet(ctx, key, ttl, load)
+		}
+		if !errors.Is(err, storage.ErrLockNotAcquired) {
+			// without the lock we fall back to process-local coalescing
+			return c.loadAndSet(ctx, key, ttl, load)
+		}
+
+		select {
+		case <-ctx.Done():
+			return *new(T), ErrLoadTimeout
+		case <-ticker.C:
+		}
+
+		v, state, err := c.Lookup(ctx, key)
+		if err == nil && state == Fresh {
+			return v, nil
+		}
+		if errors.Is(err, ErrNotFound) {
+			return v, err
+		}
+	}
+}
+
+func (c *Cache[T]) loadAndSet(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error) {
+	start := time.Now()
+	v, err := load(ctx)
+	metrics.ObserveLoad(ctx, time.Since(start))
+	if errors.Is(err, ErrNotFound) {
+		if err := c.SetAbsent(ctx, key); err != nil {
+			log.Printf("cache: set absent %s: %v", key, err)
+		}
+	}
+	if err != nil {
+		return v, err
+	}
+
+	if err := c.Set(ctx, key, v, ttl); err != nil {
+		log.Printf("cache: set %s: %v", key, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotStruct = errors.New("cache: value must be a struct")
+	ErrEmpty     = errors.New("cache: value has no fields to store")
+
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// field is a single tagged struct field; nested structs are flattened
+// into "parent.child" hash fields.
+type field struct {
+	name      string
+	omitEmpty bool
+}
+
+func parseTag(f reflect.StructField) (field, bool) {
+	tag := f.Tag.Get("redis")
+	if tag == "" || tag == "-" || !f.IsExported() {
+		return field{}, false
+	}
+
+	parts := strings.Split(tag, ",")
+	if parts[0] == "" {
+		return field{}, false
+	}
+
+	out := field{name: parts[0]}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			out.omitEmpty = true
+		}
+	}
+	return out, true
+}
+
+// ToHash maps a struct (or a pointer to one) to redis hash fields using
+// its `redis` tags.
+func ToHash(v any) (map[string]interface{}, error) {
+	val := reflect.Indirect(reflect.ValueOf(v))
+	if val.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+
+	out := make(map[string]interface{})
+	if err := encodeStruct(out, "", val); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, ErrEmpty
+	}
+	return out, nil
+}
+
+func encodeStruct(out map[string]interface{}, prefix string, val reflect.Value) error {
+	for i := 0; i < val.NumField(); i++ {
+		f, ok := parseTag(val.Type().Field(i))
+		if !ok {
+			continue
+		}
+
+		fv := val.Field(i)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+
+		for fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				break
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Ptr {
+			continue
+		}
+
+		name := prefix + f.name
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			if err := encodeStruct(out, name+".", fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, err := encodeValue(fv)
+		if err != nil {
+			return fmt.Errorf("cache: field %s: %w", name, err)
+		}
+		out[name] = s
+	}
+	return nil
+}
+
+func encodeValue(v reflect.Value) (string, error) {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), nil
+		}
+	}
+
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+
+	// slices, arrays and maps are stored as json
+	b, err := json.Marshal(v.Interface())
+	return string(b), err
+}
+
+// FromHash fills the struct pointed to by dst from redis hash fields.
+func FromHash(fields map[string]string, dst any) error {
+	val := reflect.ValueOf(dst)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	return decodeStruct(fields, "", val.Elem())
+}
+
+func decodeStruct(fields map[string]string, prefix string, val reflect.Value) error {
+	for i := 0; i < val.NumField(); i++ {
+		f, ok := parseTag(val.Type().Field(i))
+		if !ok {
+			continue
+		}
+
+		name := prefix + f.name
+		fv := val.Field(i)
+
+		base := fv.Type()
+		for base.Kind() == reflect.Ptr {
+			base = base.Elem()
+		}
+
+		nested := base.Kind() == reflect.Struct && base != timeType
+		if nested && !hasPrefix(fields, name+".") {
+			continue
+		}
+		s, found := fields[name]
+		if !nested && !found {
+			continue
+		}
+
+		for fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+
+		if nested {
+			if err := decodeStruct(fields, name+".", fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := decodeValue(s, fv); err != nil {
+			return fmt.Errorf("cache: field %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func decodeValue(s string, v reflect.Value) error {
+	if v.Type() == timeType {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+	}
+
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	return json.Unmarshal([]byte(s), v.Addr().Interface())
+}
+
+func hasPrefix(fields map[string]string, prefix string) bool {
+	for k := range fields {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
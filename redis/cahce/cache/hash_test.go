@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type (
+	hashAddress struct {
+		City string `redis:"city"`
+		Zip  string `redis:"zip,omitempty"`
+	}
+
+	hashUser struct {
+		ID       int            `redis:"id"`
+		Name     string         `redis:"name,omitempty"`
+		Admin    bool           `redis:"admin"`
+		Score    float64        `redis:"score"`
+		Raw      []byte         `redis:"raw,omitempty"`
+		Tags     []string       `redis:"tags,omitempty"`
+		Attrs    map[string]int `redis:"attrs,omitempty"`
+		Created  time.Time      `redis:"created"`
+		Seen     *time.Time     `redis:"seen"`
+		Nick     *string        `redis:"nick"`
+		Home     hashAddress    `redis:"home"`
+		Work     *hashAddress   `redis:"work"`
+		Skipped  string         `redis:"-"`
+		Untagged string
+		private  string            `redis:"private"`
+		Extra    map[string]string `redis:"-"`
+	}
+)
+
+func TestToHash(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 500, time.UTC)
+	nick := "jo"
+
+	tests := []struct {
+		name    string
+		in      any
+		want    map[string]interface{}
+		wantErr error
+	}{
+		{
+			name: "zero value",
+			in:   hashUser{},
+			want: map[string]interface{}{
+				"id": "0", "admin": "false", "score": "0",
+				"created": "0001-01-01T00:00:00Z", "home.city": "",
+			},
+		},
+		{
+			name: "full",
+			in: &hashUser{
+				ID: 7, Name: "Jo", Admin: true, Score: 1.5, Raw: []byte("x"),
+				Tags: []string{"a", "b"}, Attrs: map[string]int{"k": 1},
+				Created: created, Seen: &created, Nick: &nick,
+				Home:    hashAddress{City: "Oslo", Zip: "0150"},
+				Work:    &hashAddress{City: "Bergen"},
+				Skipped: "s", Untagged: "u", private: "p",
+			},
+			want: map[string]interface{}{
+				"id": "7", "name": "Jo", "admin": "true", "score": "1.5", "raw": "x",
+				"tags": `["a","b"]`, "attrs": `{"k":1}`,
+				"created": "2024-05-01T12:30:00.0000005Z", "seen": "2024-05-01T12:30:00.0000005Z",
+				"nick": "jo", "home.city": "Oslo", "home.zip": "0150", "work.city": "Bergen",
+			},
+		},
+		{name: "not a struct", in: 42, wantErr: ErrNotStruct},
+		{name: "nothing to store", in: struct {
+			A string `redis:"a,omitempty"`
+		}{}, wantErr: ErrEmpty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToHash(tt.in)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ToHash error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ToHash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromHash(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 500, time.UTC)
+	nick := "jo"
+
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		want    hashUser
+		wantErr bool
+	}{
+		{name: "empty", fields: map[string]string{}, want: hashUser{}},
+		{
+			name: "full",
+			fields: map[string]string{
+				"id": "7", "name": "Jo", "admin": "true", "score": "1.5", "raw": "x",
+				"tags": `["a","b"]`, "attrs": `{"k":1}`,
+				"created": "2024-05-01T12:30:00.0000005Z", "seen": "2024-05-01T12:30:00.0000005Z",
+				"nick": "jo", "home.city": "Oslo", "home.zip": "0150", "work.city": "Bergen",
+			},
+			want: hashUser{
+				ID: 7, Name: "Jo", Admin: true, Score: 1.5, Raw: []byte("x"),
+				Tags: []string{"a", "b"}, Attrs: map[string]int{"k": 1},
+				Created: created, Seen: &created, Nick: &nick,
+				Home: hashAddress{City: "Oslo", Zip: "0150"},
+				Work: &hashAddress{City: "Bergen"},
+			},
+		},
+		{
+			name:   "unknown and untagged fields are ignored",
+			fields: map[string]string{"id": "1", "__hdr": "x", "Untagged": "u", "private": "p"},
+			want:   hashUser{ID: 1},
+		},
+		{name: "bad int", fields: map[string]string{"id": "x"}, wantErr: true},
+		{name: "bad time", fields: map[string]string{"created": "yesterday"}, wantErr: true},
+		{name: "bad nested", fields: map[string]string{"work.city": "x", "score": "many"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got hashUser
+			err := FromHash(tt.fields, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromHash error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromHash = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if err := FromHash(map[string]string{}, hashUser{}); !errors.Is(err, ErrNotStruct) {
+		t.Errorf("FromHash into a non-pointer error = %v, want %v", err, ErrNotStruct)
+	}
+}
+
+func TestHashRoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("X", 3600))
+	in := hashUser{ID: 3, Created: created, Seen: &created, Work: &hashAddress{City: "Oslo"}}
+
+	fields, err := ToHash(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make(map[string]string, len(fields))
+	for k, v := range fields {
+		stored[k] = v.(string)
+	}
+
+	var out hashUser
+	if err := FromHash(stored, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.Created.Equal(in.Created) || !out.Seen.Equal(*in.Seen) {
+		t.Errorf("times = %s, %s; want %s", out.Created, out.Seen, created)
+	}
+	if out.ID != in.ID || out.Work == nil || *out.Work != *in.Work || out.Nick != nil {
+		t.Errorf("FromHash(ToHash(%+v)) = %+v", in, out)
+	}
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+var codecNames = map[byte]string{
+	hashID:    "hash",
+	jsonID:    "json",
+	msgpackID: "msgpack",
+	gobID:     "gob",
+	protoID:   "proto",
+}
+
+type (
+	// EntryInfo describes a stored entry without decoding its value.
+	EntryInfo struct {
+		Codec       string    `json:"codec"`
+		Compression string    `json:"compression,omitempty"`
+		Version     uint32    `json:"version"`
+		Absent      bool      `json:"absent,omitempty"`
+		StoredAt    time.Time `json:"stored_at"`
+		FreshUntil  time.Time `json:"fresh_until"`
+		Tags        []string  `json:"tags,omitempty"`
+	}
+)
+
+// Inspect reads the header and metadata of the hash fields of an entry.
+func Inspect(fields map[string]string) (EntryInfo, error) {
+	var info EntryInfo
+
+	id, version, err := decodeHeader(fields)
+	if err != nil {
+		return info, err
+	}
+	info.Codec, info.Version = codecNames[id], version
+	if info.Codec == "" {
+		info.Codec = "unknown(" + strconv.Itoa(int(id)) + ")"
+	}
+
+	_, info.Absent = fields[absentField]
+	if data := fields[dataField]; data != "" {
+		info.Compression = Algorithm(data[0]).String()
+	}
+	if ms, err := strconv.ParseInt(fields[freshField], 10, 64); err == nil {
+		info.FreshUntil = time.UnixMilli(ms)
+	}
+	if ms, err := strconv.ParseInt(fields[storedField], 10, 64); err == nil {
+		info.StoredAt = time.UnixMilli(ms)
+	}
+	if list := fields[tagsField]; list != "" {
+		if err := json.Unmarshal([]byte(list), &info.Tags); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
+}
+
+func (a Algorithm) String() string {
+	switch a {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return "unknown(" + strconv.Itoa(int(a)) + ")"
+	}
+}
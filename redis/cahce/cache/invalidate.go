@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Invalidate removes keys from redis and tells every instance listening on
+// Options.Channel to drop its local copies of them.
+func (c *Cache[T]) Invalidate(ctx context.Context, keys ...string) error {
+	if err := c.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	return c.Publish(ctx, keys...)
+}
+
+// Publish tells every instance that keys have changed in redis, e.g. after
+// a write-through Set, without deleting them.
+func (c *Cache[T]) Publish(ctx context.Context, keys ...string) error {
+	ctx = c.labelled(ctx)
+
+	c.drop(keys...)
+	if c.opts.Channel == "" || len(keys) == 0 {
+		return nil
+	}
+
+	_, err := c.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, key := range keys {
+			p.Publish(ctx, c.opts.Channel, key)
+		}
+		return nil
+	})
+	return err
+}
+
+// Listen drops local copies of keys published by other instances until ctx
+// is done. The subscription is restored automatically after reconnects.
+func (c *Cache[T]) Listen(ctx context.Context) error {
+	if c.opts.Channel == "" {
+		return nil
+	}
+
+	pubsub := c.db.Subscribe(ctx, c.opts.Channel)
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			c.drop(msg.Payload)
+		}
+	}
+}
+
+// drop forgets everything this process holds for keys, so the next read
+// goes back to redis instead of joining a load that predates the change.
+func (c *Cache[T]) drop(keys ...string) {
+	for _, key := range keys {
+		c.group.Forget(key)
+		if c.local != nil {
+			c.local.remove(key)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type (
+	// lru is a size and ttl bounded in-process tier in front of redis.
+	lru[T any] struct {
+		mu    sync.Mutex
+		size  int
+		ttl   time.Duration
+		ll    *list.List
+		items map[string]*list.Element
+	}
+
+	lruEntry[T any] struct {
+		key     string
+		entry   Entry[T]
+		expires time.Time
+	}
+)
+
+func newLRU[T any](size int, ttl time.Duration) *lru[T] {
+	return &lru[T]{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (l *lru[T]) get(key string) (Entry[T], bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return Entry[T]{}, false
+	}
+
+	e := el.Value.(*lruEntry[T])
+	if time.Now().After(e.expires) {
+		l.removeElement(el)
+		return Entry[T]{}, false
+	}
+
+	l.ll.MoveToFront(el)
+	return e.entry, true
+}
+
+// set stores the entry until the local ttl passes; entries never outlive
+// the redis entry they were read from.
+func (l *lru[T]) set(key string, entry Entry[T], redisExpires time.Time) {
+	expires := time.Now().Add(l.ttl)
+	if !redisExpires.IsZero() && redisExpires.Before(expires) {
+		expires = redisExpires
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.ll.MoveToFront(el)
+		el.Value = &lruEntry[T]{key: key, entry: entry, expires: expires}
+		return
+	}
+
+	l.items[key] = l.ll.PushFront(&lruEntry[T]{key: key, entry: entry, expires: expires})
+	for l.ll.Len() > l.size {
+		l.removeElement(l.ll.Back())
+	}
+}
+
+func (l *lru[T]) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.removeElement(el)
+	}
+}
+
+func (l *lru[T]) removeElement(el *list.Element) {
+	l.ll.Remove(el)
+	delete(l.items, el.Value.(*lruEntry[T]).key)
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// absentField marks a tombstone: the origin reported the key as missing.
+const absentField = "__absent"
+
+// SetAbsent remembers for NegativeTTL that the origin has no value for
+// keys, so repeated lookups of unknown keys stop reaching it. It does
+// nothing when negative caching is disabled.
+func (c *Cache[T]) SetAbsent(ctx context.Context, keys ...string) error {
+	if c.opts.NegativeTTL <= 0 || len(keys) == 0 {
+		return nil
+	}
+
+	if c.local != nil {
+		for _, key := range keys {
+			c.local.remove(key)
+		}
+	}
+
+	header := encodeHeader(c.codec.ID(), c.opts.Version)
+	_, err := c.db.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for _, key := range keys {
+			p.Del(ctx, key)
+			p.HSet(ctx, key, headerField, header, absentField, 1)
+			p.Expire(ctx, key, c.opts.NegativeTTL)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// slideScript moves the fresh time and expiry of an entry forward, unless
+// the entry was deleted since it was read.
+var slideScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// slide gives a fresh entry read from redis a full ttl again once less
+// than half of it is left, and its tag sets with it. Entries with no ttl
+// or already stale are left alone; stale ones are refreshed by reloading
+// instead.
+func (c *Cache[T]) slide(ctx context.Context, key string, e *Entry[T]) {
+	if e.TTL <= 0 || e.FreshUntil.IsZero() {
+		return
+	}
+	left := time.Until(e.FreshUntil)
+	if left <= 0 || left > e.TTL/2 {
+		return
+	}
+
+	fresh := time.Now().Add(e.TTL)
+	expire := (e.TTL + c.opts.Stale).Milliseconds()
+	if err := slideScript.Run(ctx, c.db, []string{key}, freshField, fresh.UnixMilli(), expire).Err(); err != nil {
+		log.Printf("cache: slide %s: %v", key, err)
+		return
+	}
+	e.FreshUntil = fresh
+
+	if len(e.tags) > 0 {
+		t := tagged{tags: e.tags, ttl: e.TTL + c.opts.Stale}
+		if err := c.tag(ctx, map[string]tagged{key: t}); err != nil {
+			log.Printf("cache: slide %s: tags: %v", key, err)
+		}
+	}
+}
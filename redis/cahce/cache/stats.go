@@ -0,0 +1,45 @@
+package cache
+
+import "sync/atomic"
+
+type (
+	counters struct {
+		localHits   atomic.Uint64
+		localMisses atomic.Uint64
+		redisHits   atomic.Uint64
+		redisMisses atomic.Uint64
+	}
+
+	// Stats counts lookups per tier since the cache was created. Redis is
+	// only consulted after a local miss.
+	Stats struct {
+		LocalHits   uint64 `json:"local_hits"`
+		LocalMisses uint64 `json:"local_misses"`
+		RedisHits   uint64 `json:"redis_hits"`
+		RedisMisses uint64 `json:"redis_misses"`
+	}
+)
+
+func (c *Cache[T]) Stats() Stats {
+	return Stats{
+		LocalHits:   c.stats.localHits.Load(),
+		LocalMisses: c.stats.localMisses.Load(),
+		RedisHits:   c.stats.redisHits.Load(),
+		RedisMisses: c.stats.redisMisses.Load(),
+	}
+}
+
+func (s Stats) LocalHitRatio() float64 {
+	return ratio(s.LocalHits, s.LocalMisses)
+}
+
+func (s Stats) RedisHitRatio() float64 {
+	return ratio(s.RedisHits, s.RedisMisses)
+}
+
+func ratio(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tagsField holds the JSON list of an entry's tags. Every write keeps the
+// tags an entry had, so reloading it through Set does not untag it.
+const tagsField = "__tags"
+
+// mergeTags defines tags, the entry's stored tags followed by the new
+// ones in ARGV[2], a JSON list, without duplicates.
+const mergeTags = `
+local tags, seen = {}, {}
+for _, list in ipairs({redis.call("HGET", KEYS[1], "__tags") or "[]", ARGV[2]}) do
+	for _, tag in ipairs(cjson.decode(list)) do
+		if not seen[tag] then
+			seen[tag] = true
+			tags[#tags + 1] = tag
+		end
+	end
+end
+local encoded = ""
+if #tags > 0 then
+	encoded = cjson.encode(tags)
+end
+`
+
+var (
+	// writeScript replaces entry KEYS[1] with the field value pairs from
+	// ARGV[3] on, keeping its tags, and expires it in ARGV[1] milliseconds
+	// unless that is 0. It returns {pttl, tags}.
+	writeScript = redis.NewScript(mergeTags + `
+redis.call("DEL", KEYS[1])
+redis.call("HSET", KEYS[1], unpack(ARGV, 3))
+if encoded ~= "" then
+	redis.call("HSET", KEYS[1], "__tags", encoded)
+end
+if tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {redis.call("PTTL", KEYS[1]), encoded}
+`)
+
+	// tagEntryScript adds the tags to entry KEYS[1] without touching its
+	// value. A missing entry gets a placeholder holding only its tags,
+	// which expires in ARGV[1] milliseconds unless that is 0, so the next
+	// write picks them up. It returns {pttl, tags}.
+	tagEntryScript = redis.NewScript(mergeTags + `
+local existed = redis.call("EXISTS", KEYS[1])
+redis.call("HSET", KEYS[1], "__tags", encoded)
+if existed == 0 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {redis.call("PTTL", KEYS[1]), encoded}
+`)
+
+	// invalidateTagScript deletes every key in the tag sets given as KEYS
+	// together with the sets themselves, and returns the deleted keys.
+	invalidateTagScript = redis.NewScript(`
+local deleted = {}
+for _, set in ipairs(KEYS) do
+	for _, key in ipairs(redis.call("SMEMBERS", set)) do
+		redis.call("DEL", key)
+		deleted[#deleted + 1] = key
+	end
+	redis.call("DEL", set)
+end
+return deleted
+`)
+
+	// takeTagScript deletes tag set KEYS[1] and returns its members.
+	takeTagScript = redis.NewScript(`
+local members = redis.call("SMEMBERS", KEYS[1])
+redis.call("DEL", KEYS[1])
+return members
+`)
+
+	// tagScript adds ARGV[1] to tag set KEYS[1] and makes the set live at
+	// least ARGV[2] more milliseconds, or for good when that is 0. A set
+	// never expires before the longest lived of its entries.
+	tagScript = redis.NewScript(`
+local existed = redis.call("EXISTS", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+	return 1
+end
+local left = redis.call("PTTL", KEYS[1])
+if existed == 0 or (left >= 0 and left < ttl) then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`)
+)
+
+// InvalidateTag atomically removes every key written with any of tags,
+// together with the tag sets, and tells every instance to drop its local
+// copies of them.
+//
+// The member keys of a set are not declared to the script, so a cluster
+// cannot run it. There each set is emptied atomically on its own and its
+// keys are deleted after, so keys tagged meanwhile stay in the new set; a
+// failed delete leaves the keys cached but untagged until they expire.
+func (c *Cache[T]) InvalidateTag(ctx context.Context, tags ...string) error {
+	ctx = c.labelled(ctx)
+	if len(tags) == 0 {
+		return nil
+	}
+
+	sets := make([]string, len(tags))
+	for i, tag := range tags {
+		sets[i] = c.tagKey(tag)
+	}
+
+	if _, ok := c.db.(*redis.ClusterClient); ok {
+		return c.invalidateTagSets(ctx, sets)
+	}
+	keys, err := invalidateTagScript.Run(ctx, c.db, sets).StringSlice()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	return c.Publish(ctx, keys...)
+}
+
+// invalidateTagSets is InvalidateTag for a cluster, where tag sets and
+// their keys may live in different slots.
+func (c *Cache[T]) invalidateTagSets(ctx context.Context, sets []string) error {
+	cmds := make([]*redis.Cmd, len(sets))
+	_, err := c.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, set := range sets {
+			cmds[i] = takeTagScript.Eval(ctx, p, []string{set})
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	var keys []string
+	for _, cmd := range cmds {
+		members, err := cmd.StringSlice()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		keys = append(keys, members...)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.Invalidate(ctx, keys...)
+}
+
+// Tag adds key to every tag in tags without writing a value, e.g. for keys
+// that are invalidated rather than written through. The tags are kept for
+// the value written next, for ttl at most if none is.
+func (c *Cache[T]) Tag(ctx context.Context, key string, ttl time.Duration, tags ...string) error {
+	ctx = c.labelled(ctx)
+	if len(tags) == 0 {
+		return nil
+	}
+
+	list, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	var expire int64
+	if ttl > 0 {
+		expire = (ttl + c.opts.Jitter + c.opts.Stale).Milliseconds()
+	}
+
+	res, err := tagEntryScript.Run(ctx, c.db, []string{key}, expire, list).Slice()
+	if err != nil {
+		return err
+	}
+	pttl, all, err := parseTagged(res)
+	if err != nil {
+		return err
+	}
+	return c.tag(ctx, map[string]tagged{key: {tags: all, ttl: pttl}})
+}
+
+// tagged is what an entry was tagged with, and for how long it lives.
+type tagged struct {
+	tags []string
+	ttl  time.Duration
+}
+
+// tag adds every key to the sets of its tags, one single-key script per
+// set, extending each set to outlive the key.
+func (c *Cache[T]) tag(ctx context.Context, keys map[string]tagged) error {
+	_, err := c.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for key, t := range keys {
+			for _, tag := range t.tags {
+				tagScript.Eval(ctx, p, []string{c.tagKey(tag)}, key, t.ttl.Milliseconds())
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// parseTagged reads the {pttl, tags} reply of writeScript and
+// tagEntryScript. A key without expiry has a zero ttl.
+func parseTagged(res []interface{}) (time.Duration, []string, error) {
+	if len(res) != 2 {
+		return 0, nil, fmt.Errorf("cache: unexpected script result %v", res)
+	}
+	var ttl time.Duration
+	if ms, _ := res[0].(int64); ms > 0 {
+		ttl = time.Duration(ms) * time.Millisecond
+	}
+
+	var tags []string
+	if list, _ := res[1].(string); list != "" {
+		if err := json.Unmarshal([]byte(list), &tags); err != nil {
+			return 0, nil, err
+		}
+	}
+	return ttl, tags, nil
+}
+
+func (c *Cache[T]) tagKey(tag string) string {
+	prefix := c.opts.TagPrefix
+	if prefix == "" {
+		prefix = c.opts.Name
+	}
+	return prefix + ":tag:" + tag
+}
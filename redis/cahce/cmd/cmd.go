@@ -2,15 +2,100 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"my-go-app/redis/cahce/cache"
 	"my-go-app/redis/cahce/handlers"
+	"my-go-app/redis/cahce/ratelimit"
 	"my-go-app/redis/cahce/storage"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+type (
+	// services are the connections and the card store shared by every
+	// subcommand.
+	services struct {
+		db      redis.UniversalClient
+		pg      *sql.DB
+		breaker *storage.Breaker
+		store   *handlers.CardStore
+	}
+)
+
+// Usage:
+//
+//	cmd            serve the card API on localhost:8080
+//	cmd warmup ... load cards into the cache, see warmup -h
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "warmup" {
+		os.Exit(warmup(os.Args[2:]))
+	}
+	serve()
+}
+
+func serve() {
+	svc := setup(context.Background())
+	defer svc.pg.Close()
+
+	limiter, err := ratelimit.New(svc.db, ratelimit.Config{
+		Algorithm: ratelimit.GCRA,
+		Prefix:    "cards:rate",
+		Default:   ratelimit.Limit{Rate: 50, Period: time.Second, Burst: 100},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	inflight, err := ratelimit.NewSemaphore(svc.db, ratelimit.SemaphoreConfig{
+		Prefix:    "cards:inflight",
+		Limit:     64,
+		TTL:       30 * time.Second,
+		AutoRenew: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	router := chi.NewRouter()
+	router.Group(func(r chi.Router) {
+		r.Use(ratelimit.Middleware(limiter, ratelimit.MiddlewareConfig{
+			// nothing here verifies API keys, and an unverified header would
+			// let clients pick a fresh bucket per request
+			Key:     ratelimit.ByIP(nil),
+			Failure: ratelimit.FailOpen,
+		}))
+
+		// slow origin reads pile up in postgres long before they hit the rate limit
+		r.With(ratelimit.ConcurrencyMiddleware(inflight, ratelimit.ConcurrencyConfig{
+			Name:    "card",
+			Wait:    100 * time.Millisecond,
+			Failure: ratelimit.FailOpen,
+		})).Route("/card", handlers.NewCardHandler(svc.store))
+		r.Route("/admin/cache", handlers.NewAdminHandler(svc.db, handlers.AdminConfig{
+			Auth:       handlers.TokenAuth(os.Getenv("CACHE_ADMIN_TOKEN")),
+			Namespaces: []*storage.Namespace{svc.store.Namespace()},
+			Stats:      map[string]func() cache.Stats{"card": svc.store.Stats},
+		}))
+	})
+	router.Handle("/metrics", promhttp.Handler())
+	router.Get("/health", handlers.Health(svc.breaker))
+
+	srv := http.Server{
+		Addr:    "localhost:8080",
+		Handler: router,
+	}
+
+	if err := srv.ListenAndServe(); err != nil {
+		panic(err)
+	}
+}
+
+func setup(ctx context.Context) services {
 	cfg := storage.Config{
 		Addr:        "localhost:6379",
 		Password:    "",
@@ -19,22 +104,56 @@ func main() {
 		MaxRetries:  5,
 		DialTimeout: 10 * time.Second,
 		Timeout:     5 * time.Second,
+		TTL: storage.TTLConfig{
+			Default: storage.TTLPolicy{TTL: 30 * time.Second, Jitter: 5 * time.Second},
+		},
 	}
 
-	db, err := storage.NewClient(context.Background(), cfg)
+	db, err := storage.NewClient(ctx, cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	router := chi.NewRouter()
-	router.Route("/card", handlers.NewCardHandler(context.Background(), db))
+	pg, err := storage.NewPostgres(ctx, storage.PostgresConfig{
+		Host:     "localhost",
+		Port:     5432,
+		User:     "postgres",
+		Password: "postgres",
+		DBName:   "postgres",
+	})
+	if err != nil {
+		panic(err)
+	}
 
-	srv := http.Server{
-		Addr:    "localhost:8080",
-		Handler: router,
+	cards := handlers.NewPostgresCardRepository(pg)
+	// instances starting together would otherwise race to create the schema
+	locker := storage.NewLocker(db, storage.LockConfig{TTL: 30 * time.Second, AutoRenew: true})
+	if err := locker.WithLock(ctx, "cards:migrate:lock", cards.Migrate); err != nil {
+		panic(err)
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
+	breaker := storage.NewBreaker("cache", storage.BreakerConfig{
+		FailureThreshold: 5,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenRequests: 1,
+	})
+	db.AddHook(breaker)
+
+	bloom := storage.NewBloom(db, storage.BloomConfig{
+		Key:           "cards:card:bloom",
+		Capacity:      1_000_000,
+		FalsePositive: 0.01,
+	})
+	if err := handlers.SeedBloom(ctx, bloom, cards); err != nil {
 		panic(err)
 	}
+
+	store := handlers.NewCardStore(ctx, db, cards, handlers.CardStoreConfig{
+		Breaker: breaker,
+		Bloom:   bloom,
+		Mode:    handlers.WriteThrough,
+		TTL:     cfg.TTL.For("/card/{id}", "card"),
+	})
+
+	return services{db: db, pg: pg, breaker: breaker, store: store}
 }
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"my-go-app/redis/cahce/cache"
+	"my-go-app/redis/cahce/handlers"
+	"my-go-app/redis/cahce/metrics"
+	"my-go-app/redis/cahce/storage"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/segmentio/kafka-go"
+)
+
+const warmupProgressEvery = 5 * time.Second
+
+type (
+	// idSource sends card ids to ids and returns once all were sent.
+	idSource func(ctx context.Context, ids chan<- int) error
+
+	warmupCounts struct {
+		loaded, cached, missing, failed atomic.Int64
+	}
+)
+
+// warmup loads cards into the cache ahead of traffic and returns the exit
+// status: 1 when the source failed or any card could not be loaded.
+func warmup(args []string) int {
+	fs := flag.NewFlagSet("warmup", flag.ExitOnError)
+	source := fs.String("source", "postgres", "where card ids come from: file, postgres or kafka")
+	file := fs.String("file", "", "file with one card id per line, for -source=file")
+	query := fs.String("query", "SELECT id FROM cards", "query returning card ids, for -source=postgres")
+	brokers := fs.String("brokers", "localhost:9092", "comma separated kafka brokers, for -source=kafka")
+	topic := fs.String("topic", "cards", "compacted topic keyed by card id, for -source=kafka")
+	workers := fs.Int("workers", 8, "number of cards loaded concurrently")
+	rate := fs.Int("rate", 100, "cards loaded per second at most, from 1 to 1e9")
+	metricsAddr := fs.String("metrics", "", "address serving /metrics while warming up, e.g. localhost:9102")
+	fs.Parse(args)
+
+	if *workers <= 0 {
+		log.Printf("warmup: -workers must be positive")
+		return 2
+	}
+	// a faster rate would make the ticker interval zero
+	if *rate < 1 || *rate > int(time.Second) {
+		log.Printf("warmup: -rate must be between 1 and %d", int(time.Second))
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	svc := setup(ctx)
+	defer svc.pg.Close()
+
+	var read idSource
+	switch *source {
+	case "file":
+		read = fileIDs(*file)
+	case "postgres":
+		read = postgresIDs(svc.pg, *query)
+	case "kafka":
+		read = kafkaIDs(strings.Split(*brokers, ","), *topic)
+	default:
+		log.Printf("warmup: unknown source %q", *source)
+		return 2
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				log.Printf("warmup: metrics: %v", err)
+			}
+		}()
+	}
+
+	ids := make(chan int, *workers)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(ids)
+		readErr <- read(ctx, ids)
+	}()
+
+	start := time.Now()
+	var counts warmupCounts
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(warmupProgressEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Printf("warmup: %s after %s", &counts, time.Since(start).Round(time.Second))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	warm(ctx, svc, *source, ids, *workers, *rate, &counts)
+	close(done)
+
+	log.Printf("warmup: finished in %s: %s", time.Since(start).Round(time.Millisecond), &counts)
+	// warm only stops early once ctx is done, which also stops read
+	if err := <-readErr; err != nil {
+		log.Printf("warmup: reading ids from %s: %v", *source, err)
+		return 1
+	}
+	if ctx.Err() != nil || counts.failed.Load() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// warm fetches every id through the card store with at most workers
+// fetches in flight and at most rate started per second.
+func warm(ctx context.Context, svc services, source string, ids <-chan int, workers, rate int, counts *warmupCounts) {
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				result := warmCard(ctx, svc, id)
+				counts.add(result)
+				metrics.ObserveWarmup(source, result)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+
+dispatch:
+	for id := range ids {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break dispatch
+		}
+		select {
+		case jobs <- id:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func warmCard(ctx context.Context, svc services, id int) string {
+	// with the breaker open the store reads the origin without caching
+	if svc.breaker.Open() {
+		log.Printf("warmup: card %d: %v", id, storage.ErrBreakerOpen)
+		return metrics.WarmFailed
+	}
+
+	e, err := svc.store.Fetch(ctx, id)
+	switch {
+	case errors.Is(err, handlers.ErrCardNotFound):
+		return metrics.WarmMissing
+	case err != nil:
+		log.Printf("warmup: card %d: %v", id, err)
+		return metrics.WarmFailed
+	case e.State == cache.Miss:
+		return metrics.WarmLoaded
+	default:
+		return metrics.WarmCached
+	}
+}
+
+func (c *warmupCounts) add(result string) {
+	switch result {
+	case metrics.WarmLoaded:
+		c.loaded.Add(1)
+	case metrics.WarmCached:
+		c.cached.Add(1)
+	case metrics.WarmMissing:
+		c.missing.Add(1)
+	default:
+		c.failed.Add(1)
+	}
+}
+
+func (c *warmupCounts) String() string {
+	return fmt.Sprintf("%d loaded, %d already cached, %d missing, %d failed",
+		c.loaded.Load(), c.cached.Load(), c.missing.Load(), c.failed.Load())
+}
+
+// fileIDs reads one id per line, skipping blank lines and # comments.
+func fileIDs(path string) idSource {
+	return func(ctx context.Context, ids chan<- int) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			id, err := strconv.Atoi(line)
+			if err != nil {
+				return fmt.Errorf("%s:%d: invalid card id %q", path, n, line)
+			}
+			if err := send(ctx, ids, id); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+}
+
+// postgresIDs streams the first column of query's rows.
+func postgresIDs(pg *sql.DB, query string) idSource {
+	return func(ctx context.Context, ids chan<- int) error {
+		rows, err := pg.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			if err := send(ctx, ids, id); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+}
+
+// kafkaIDs reads every partition of a compacted topic up to its end as of
+// the start of the warm-up. Message keys are card ids; a tombstone, i.e.
+// a message without value, removes its id again.
+func kafkaIDs(brokers []string, topic string) idSource {
+	return func(ctx context.Context, ids chan<- int) error {
+		conn, err := kafka.DialContext(ctx, "tcp", brokers[0])
+		if err != nil {
+			return err
+		}
+		partitions, err := conn.ReadPartitions(topic)
+		conn.Close()
+		if err != nil {
+			return err
+		}
+
+		var order []int
+		seen, live := make(map[int]bool), make(map[int]bool)
+		for _, p := range partitions {
+			err := readPartition(ctx, brokers, topic, p.ID, func(msg kafka.Message) {
+				id, err := strconv.Atoi(string(msg.Key))
+				if err != nil {
+					log.Printf("warmup: %s/%d@%d: invalid card id %q", topic, p.ID, msg.Offset, msg.Key)
+					return
+				}
+				if msg.Value == nil {
+					delete(live, id)
+					return
+				}
+				if !seen[id] {
+					seen[id] = true
+					order = append(order, id)
+				}
+				live[id] = true
+			})
+			if err != nil {
+				return fmt.Errorf("partition %d: %w", p.ID, err)
+			}
+		}
+
+		for _, id := range order {
+			if !live[id] {
+				continue
+			}
+			if err := send(ctx, ids, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func readPartition(ctx context.Context, brokers []string, topic string, partition int, fn func(kafka.Message)) error {
+	leader, err := kafka.DialLeader(ctx, "tcp", brokers[0], topic, partition)
+	if err != nil {
+		return err
+	}
+	first, last, err := leader.ReadOffsets()
+	leader.Close()
+	if err != nil || first >= last {
+		return err
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   brokers,
+		Topic:     topic,
+		Partition: partition,
+		MaxBytes:  10e6,
+	})
+	defer r.Close()
+	if err := r.SetOffset(first); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := r.ReadMessage(ctx)
+		if err != nil {
+			return err
+		}
+		fn(msg)
+		if msg.Offset+1 >= last {
+			return nil
+		}
+	}
+}
+
+func send(ctx context.Context, ids chan<- int, id int) error {
+	select {
+	case ids <- id:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"my-go-app/redis/cahce/cache"
+	"my-go-app/redis/cahce/storage"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultScanCount = 100
+	maxScanCount     = 1000
+)
+
+type (
+	AdminConfig struct {
+		// Auth guards every admin route. It is required; see TokenAuth.
+		Auth func(http.Handler) http.Handler
+		// Namespaces can be dropped as a whole by bumping their generation.
+		Namespaces []*storage.Namespace
+		// Stats reports the hit ratios of each named cache.
+		Stats map[string]func() cache.Stats
+	}
+
+	admin struct {
+		db     redis.UniversalClient
+		spaces map[string]*storage.Namespace
+		stats  map[string]func() cache.Stats
+	}
+
+	keyResponse struct {
+		Key      string            `json:"key"`
+		Type     string            `json:"type"`
+		TTL      string            `json:"ttl"`
+		Encoding string            `json:"encoding,omitempty"`
+		Size     int64             `json:"size_bytes,omitempty"`
+		Entry    *cache.EntryInfo  `json:"entry,omitempty"`
+		Value    map[string]string `json:"value,omitempty"`
+		Length   int64             `json:"length,omitempty"`
+	}
+
+	scanResponse struct {
+		Keys   []string `json:"keys"`
+		Cursor uint64   `json:"cursor"`
+	}
+
+	deleteResponse struct {
+		Deleted int64 `json:"deleted"`
+	}
+
+	bumpResponse struct {
+		Namespace  string `json:"namespace"`
+		Generation int64  `json:"generation"`
+	}
+
+	adminStatsResponse struct {
+		Caches map[string]statsResponse `json:"caches"`
+		Redis  map[string]string        `json:"redis"`
+	}
+)
+
+// NewAdminHandler mounts the cache administration routes:
+//
+//	GET    /keys?namespace=&match=&cursor=&count=  SCAN keys page by page
+//	GET    /key?key=                               inspect a key
+//	DELETE /key?key=&key=                          delete keys
+//	GET    /namespaces                             list droppable namespaces
+//	DELETE /namespaces/{namespace}                 drop a namespace
+//	GET    /stats                                  cache and redis stats
+//
+// On a cluster, SCAN and INFO only see the node the command lands on.
+func NewAdminHandler(db redis.UniversalClient, cnf AdminConfig) func(r chi.Router) {
+	if cnf.Auth == nil {
+		panic("admin routes need an auth middleware")
+	}
+
+	a := &admin{db: db, spaces: make(map[string]*storage.Namespace), stats: cnf.Stats}
+	for _, ns := range cnf.Namespaces {
+		a.spaces[ns.Space().Prefix()] = ns
+	}
+
+	return func(r chi.Router) {
+		r.Use(cnf.Auth)
+
+		r.Get("/keys", a.scan)
+		r.Get("/key", a.inspect)
+		r.Delete("/key", a.delete)
+		r.Get("/namespaces", a.namespaces)
+		r.Delete("/namespaces/{namespace}", a.bump)
+		r.Get("/stats", a.statsHandler)
+	}
+}
+
+// TokenAuth accepts requests carrying one of tokens as a bearer token.
+// Without tokens every request is rejected.
+func TokenAuth(tokens ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if ok {
+				for _, token := range tokens {
+					if token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			render.Status(r, http.StatusUnauthorized)
+			render.JSON(w, r, errorResponse{Error: "unauthorized"})
+		})
+	}
+}
+
+func (a *admin) scan(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	match := q.Get("match")
+	if match == "" {
+		match = "*"
+	}
+	if ns := q.Get("namespace"); ns != "" {
+		match = ns + ":" + match
+	}
+
+	cursor, err := parseUint(q.Get("cursor"), 0)
+	if err != nil {
+		adminError(w, r, http.StatusBadRequest, "invalid cursor")
+		return
+	}
+	count, err := parseUint(q.Get("count"), defaultScanCount)
+	if err != nil || count == 0 || count > maxScanCount {
+		adminError(w, r, http.StatusBadRequest, "count must be between 1 and "+strconv.Itoa(maxScanCount))
+		return
+	}
+
+	keys, next, err := a.db.Scan(r.Context(), cursor, match, int64(count)).Result()
+	if err != nil {
+		adminError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+
+	render.JSON(w, r, scanResponse{Keys: keys, Cursor: next})
+}
+
+func (a *admin) inspect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		adminError(w, r, http.StatusBadRequest, "key is required")
+		return
+	}
+
+	var (
+		typ      *redis.StatusCmd
+		ttl      *redis.DurationCmd
+		encoding *redis.StringCmd
+		size     *redis.IntCmd
+	)
+	// MEMORY USAGE and OBJECT ENCODING may be disabled, so their errors
+	// are ignored below
+	a.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		typ = p.Type(ctx, key)
+		ttl = p.PTTL(ctx, key)
+		encoding = p.ObjectEncoding(ctx, key)
+		size = p.MemoryUsage(ctx, key)
+		return nil
+	})
+	if err := typ.Err(); err != nil {
+		adminError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if typ.Val() == "none" {
+		adminError(w, r, http.StatusNotFound, "key not found")
+		return
+	}
+
+	resp := keyResponse{
+		Key:      key,
+		Type:     typ.Val(),
+		TTL:      formatTTL(ttl.Val()),
+		Encoding: encoding.Val(),
+		Size:     size.Val(),
+	}
+
+	var err error
+	switch resp.Type {
+	case "hash":
+		var fields map[string]string
+		fields, err = a.db.HGetAll(ctx, key).Result()
+		if info, err := cache.Inspect(fields); err == nil {
+			resp.Entry = &info
+		}
+		resp.Value = printable(fields)
+		resp.Length = int64(len(fields))
+	case "string":
+		var v string
+		v, err = a.db.Get(ctx, key).Result()
+		resp.Value = printable(map[string]string{"value": v})
+		resp.Length = int64(len(v))
+	case "list":
+		resp.Length, err = a.db.LLen(ctx, key).Result()
+	case "set":
+		resp.Length, err = a.db.SCard(ctx, key).Result()
+	case "zset":
+		resp.Length, err = a.db.ZCard(ctx, key).Result()
+	}
+	if err != nil && err != redis.Nil {
+		adminError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	render.JSON(w, r, resp)
+}
+
+func (a *admin) delete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	keys := r.URL.Query()["key"]
+	if len(keys) == 0 {
+		adminError(w, r, http.StatusBadRequest, "key is required")
+		return
+	}
+
+	deleted, err := storage.DeleteKeys(ctx, a.db, keys...)
+	if err != nil {
+		adminError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.JSON(w, r, deleteResponse{Deleted: deleted})
+}
+
+func (a *admin) namespaces(w http.ResponseWriter, r *http.Request) {
+	spaces := make([]storage.KeySpace, 0, len(a.spaces))
+	for _, ns := range a.spaces {
+		spaces = append(spaces, ns.Space())
+	}
+	render.JSON(w, r, spaces)
+}
+
+// bump drops every key of a namespace at once by moving it to a new
+// generation; the old keys are purged in the background.
+func (a *admin) bump(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "namespace")
+	ns, ok := a.spaces[name]
+	if !ok {
+		adminError(w, r, http.StatusNotFound, "unknown namespace "+strconv.Quote(name))
+		return
+	}
+
+	gen, err := ns.Bump(r.Context())
+	if err != nil {
+		adminError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render.JSON(w, r, bumpResponse{Namespace: name, Generation: gen})
+}
+
+func (a *admin) statsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := adminStatsResponse{
+		Caches: make(map[string]statsResponse, len(a.stats)),
+		Redis:  make(map[string]string),
+	}
+	for name, stats := range a.stats {
+		s := stats()
+		resp.Caches[name] = statsResponse{
+			Stats:         s,
+			LocalHitRatio: s.LocalHitRatio(),
+			RedisHitRatio: s.RedisHitRatio(),
+		}
+	}
+
+	info, err := a.db.Info(r.Context()).Result()
+	if err != nil {
+		adminError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, line := range strings.Split(info, "\r\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case k == "keyspace_hits", k == "keyspace_misses", k == "evicted_keys", k == "expired_keys",
+			k == "used_memory", k == "used_memory_human", k == "maxmemory", k == "maxmemory_policy",
+			strings.HasPrefix(k, "db"):
+			resp.Redis[k] = v
+		}
+	}
+
+	render.JSON(w, r, resp)
+}
+
+func adminError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	render.Status(r, status)
+	render.JSON(w, r, errorResponse{Error: msg})
+}
+
+// printable leaves valid UTF-8 values as they are and base64 encodes the
+// rest, e.g. compressed blobs, prefixed with "base64:".
+func printable(fields map[string]string) map[string]string {
+	out := make(map[string]string, len(fields))
+	for k, v := range fields {
+		if !utf8.ValidString(v) {
+			v = "base64:" + base64.StdEncoding.EncodeToString([]byte(v))
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func formatTTL(ttl time.Duration) string {
+	switch ttl {
+	case -1:
+		return "none"
+	case -2:
+		return "expired"
+	}
+	return ttl.String()
+}
+
+func parseUint(s string, def uint64) (uint64, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"my-go-app/redis/cahce/cache"
+
+	"github.com/go-chi/render"
+)
+
+// maxBatchCards bounds the number of ids accepted by GetCards.
+const maxBatchCards = 100
+
+type (
+	// batchItem is one card of a GetCards response: either the card or
+	// the reason it could not be returned.
+	batchItem struct {
+		ID    int         `json:"id"`
+		Card  *Card       `json:"card,omitempty"`
+		Cache cache.State `json:"cache,omitempty"`
+		Error string      `json:"error,omitempty"`
+	}
+)
+
+// GetCards returns the cards listed in the ids query parameter, e.g.
+// ?ids=1,2,3, in the requested order.
+func GetCards(store *CardStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids, err := parseIDs(r.URL.Query().Get("ids"))
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
+		}
+
+		entries, errs := store.FetchMany(r.Context(), ids)
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			return
+		}
+
+		items := make([]batchItem, len(ids))
+		for i, id := range ids {
+			items[i] = batchItem{ID: id}
+			if errs[i] != nil {
+				items[i].Error = errs[i].Error()
+				continue
+			}
+			card := entries[i].Value
+			items[i].Card = &card
+			items[i].Cache = entries[i].State
+		}
+
+		render.JSON(w, r, items)
+	}
+}
+
+func parseIDs(param string) ([]int, error) {
+	if param == "" {
+		return nil, errors.New("ids is required")
+	}
+
+	parts := strings.Split(param, ",")
+	if len(parts) > maxBatchCards {
+		return nil, errors.New("too many ids, at most " + strconv.Itoa(maxBatchCards) + " are allowed")
+	}
+
+	ids := make([]int, len(parts))
+	for i, part := range parts {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, errors.New("invalid card id " + strconv.Quote(part))
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
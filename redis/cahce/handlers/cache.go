@@ -2,84 +2,89 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"reflect"
 	"strconv"
 	"time"
 
+	"my-go-app/redis/cahce/cache"
+	"my-go-app/redis/cahce/metrics"
+	"my-go-app/redis/cahce/storage"
+
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 	"github.com/go-redis/redis/v8"
 )
 
+const (
+	cardTTL      = 30 * time.Second
+	cardStaleTTL = 5 * time.Minute
+	cardChannel  = "card:invalidate"
+	cardMissTTL  = 10 * time.Second
+
+	// CacheHeader reports whether a response was served from a fresh or
+	// stale cache entry, or was a miss.
+	CacheHeader = "X-Cache"
+)
+
+var (
+	cardKeys      = storage.KeySpace{Service: "cards", Entity: "card", Version: 1}
+	cardTTLPolicy = storage.TTLPolicy{TTL: cardTTL, Jitter: cardTTL / 10}
+	cardPolicy    = HTTPPolicy{TTLPolicy: cardTTLPolicy, Stale: cardStaleTTL}
+)
+
 type (
 	Card struct {
 		ID   int    `json:"id" redis:"id"`
 		Name string `json:"name" redis:"name"`
 		Data string `json:"data" redis:"data"`
 	}
-)
-
-func (c *Card) ToRerdisCard(ctx context.Context, db *redis.Client, key string) error {
-
-	val := reflect.ValueOf(c).Elem()
-
-	setter := func(p redis.Pipeliner) error {
-		for i := 0; i < val.NumField(); i++ {
-			field := val.Type().Field(i)
-
-			tag := field.Tag.Get("redis")
-
-			if err := p.HSet(ctx, key, tag, val.Field(i).Interface()).Err(); err != nil {
-				return err
-			}
-		}
 
-		if err := p.Expire(ctx, key, 30*time.Second).Err(); err != nil {
-			return err
-		}
-		return nil
+	errorResponse struct {
+		Error string `json:"error"`
 	}
 
-	if _, err := db.Pipelined(ctx, setter); err != nil {
-		return err
+	statsResponse struct {
+		cache.Stats
+		LocalHitRatio float64 `json:"local_hit_ratio"`
+		RedisHitRatio float64 `json:"redis_hit_ratio"`
 	}
+)
 
-	return nil
-}
-
-func GetCard(ctx context.Context, db *redis.Client) http.HandlerFunc {
+func GetCard(store *CardStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		time.Sleep(3 * time.Second)
-
-		idStr := chi.URLParam(r, "id")
-		if idStr == "" {
-			render.Status(r, http.StatusBadRequest)
+		id, ok := cardID(w, r)
+		if !ok {
 			return
 		}
 
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			render.Status(r, http.StatusBadRequest)
+		e, err := store.Fetch(r.Context(), id)
+		if errors.Is(err, context.Canceled) {
 			return
 		}
-
-		card := Card{
-			ID:   id,
-			Name: "Test card",
-			Data: "This is a test card",
+		if errors.Is(err, ErrCardNotFound) {
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
 		}
-
-		if err := card.ToRerdisCard(ctx, db, idStr); err != nil {
+		if errors.Is(err, cache.ErrLoadTimeout) {
+			render.Status(r, http.StatusGatewayTimeout)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
 			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
 		}
 
-		render.Status(r, 200)
-		render.JSON(w, r, card)
+		serveCard(w, r, e)
 	}
 }
 
-func CacheMiddleware(ctx context.Context, db *redis.Client) func(http.Handler) http.Handler {
+func CacheMiddleware(store *CardStore) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			idStr := chi.URLParam(r, "id")
@@ -88,19 +93,105 @@ func CacheMiddleware(ctx context.Context, db *redis.Client) func(http.Handler) h
 				return
 			}
 
-			data := new(Card)
-			if err := db.HGetAll(ctx, idStr).Scan(data); err == nil && (*data != Card{}) {
-				render.JSON(w, r, data)
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			e, err := store.Lookup(r.Context(), id)
+			if errors.Is(err, cache.ErrNotFound) ||
+				errors.Is(err, cache.ErrMiss) && !store.MightExist(r.Context(), id) {
+				render.Status(r, http.StatusNotFound)
+				render.JSON(w, r, errorResponse{Error: ErrCardNotFound.Error()})
 				return
 			}
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if e.State == cache.Stale {
+				store.Refresh(r.Context(), id)
+			}
 
-			next.ServeHTTP(w, r)
+			serveCard(w, r, e)
 		})
 	}
 }
 
-func NewCardHandler(ctx context.Context, db *redis.Client) func(r chi.Router) {
+func serveCard(w http.ResponseWriter, r *http.Request, e cache.Entry[Card]) {
+	body, err := json.Marshal(e.Value)
+	if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, errorResponse{Error: err.Error()})
+		return
+	}
+
+	serveEntry(w, r, e, cardPolicy, http.StatusOK, nil, body)
+}
+
+// CardStats reports per-tier hit ratios of the card cache.
+func CardStats(store *CardStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := store.Stats()
+		render.JSON(w, r, statsResponse{
+			Stats:         stats,
+			LocalHitRatio: stats.LocalHitRatio(),
+			RedisHitRatio: stats.RedisHitRatio(),
+		})
+	}
+}
+
+// NewCardStore builds the card cache in front of repo. Invalidations are
+// exchanged with other instances, and failed ones retried, until ctx is
+// done.
+func NewCardStore(ctx context.Context, db redis.UniversalClient, repo CardRepository, cnf CardStoreConfig) *CardStore {
+	ttl := cnf.TTL.Or(cardTTLPolicy)
+
+	cards := cache.New[Card](db, cache.Options{
+		Name:        cardKeys.Prefix(),
+		LoadTimeout: 5 * time.Second,
+		LockTTL:     10 * time.Second,
+		Stale:       cardStaleTTL,
+		Channel:     cardChannel,
+		LocalSize:   1000,
+		LocalTTL:    5 * time.Second,
+		NegativeTTL: cardMissTTL,
+		Jitter:      ttl.Jitter,
+		Sliding:     ttl.Sliding,
+	})
+
+	go func() {
+		if err := cards.Listen(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("card invalidations: %v", err)
+		}
+	}()
+
+	store := &CardStore{
+		cards:   cards,
+		keys:    storage.NewNamespace(db, cardKeys, time.Second),
+		breaker: cnf.Breaker,
+		bloom:   cnf.Bloom,
+		repo:    repo,
+		mode:    cnf.Mode,
+		ttl:     ttl,
+	}
+	go store.retryPending(ctx)
+	return store
+}
+
+// NewCardHandler mounts the card routes.
+func NewCardHandler(store *CardStore) func(r chi.Router) {
 	return func(r chi.Router) {
-		r.With(CacheMiddleware(ctx, db)).Get("/{id}", GetCard(ctx, db))
+		route := r.With(metrics.Route)
+
+		route.Get("/stats", CardStats(store))
+		route.Get("/", GetCards(store))
+		route.With(CacheMiddleware(store)).Get("/{id}", GetCard(store))
+		route.Put("/{id}", PutCard(store))
+		route.Patch("/{id}", PatchCard(store))
+		route.Delete("/{id}", DeleteCard(store))
+		route.Delete("/tags/{tag}", InvalidateCardTag(store))
 	}
 }
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"my-go-app/redis/cahce/storage"
+
+	"github.com/go-chi/render"
+)
+
+type healthResponse struct {
+	Status  string `json:"status"`
+	Breaker string `json:"redis_breaker"`
+}
+
+// Health reports the redis circuit breaker. An open breaker leaves the
+// service degraded but serving from the origin, so it still answers 200.
+func Health(breaker *storage.Breaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := breaker.State()
+
+		status := "ok"
+		if state != storage.BreakerClosed {
+			status = "degraded"
+		}
+
+		render.JSON(w, r, healthResponse{Status: status, Breaker: state.String()})
+	}
+}
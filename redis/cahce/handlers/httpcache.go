@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-go-app/redis/cahce/cache"
+	"my-go-app/redis/cahce/storage"
+
+	"github.com/go-chi/chi"
+)
+
+type (
+	// HTTPPolicy describes how cached responses are advertised to clients.
+	// Vary lists the request headers a response depends on; ResponseCache
+	// also keys entries by their values. Jitter and Sliding are up to the
+	// cache's Options.
+	HTTPPolicy struct {
+		storage.TTLPolicy
+		Stale time.Duration
+		Vary  []string
+	}
+
+	// Response is a handler response as captured by ResponseCache.
+	Response struct {
+		Status int         `redis:"status"`
+		Header http.Header `redis:"header"`
+		Body   []byte      `redis:"body"`
+	}
+
+	// uncacheable carries a response that was served but must not be
+	// stored, along with the request it answers.
+	uncacheable struct {
+		resp Response
+		req  *http.Request
+	}
+
+	captureWriter struct {
+		header http.Header
+		status int
+		body   bytes.Buffer
+	}
+)
+
+func (u *uncacheable) Error() string {
+	return fmt.Sprintf("response with status %d is not cacheable", u.resp.Status)
+}
+
+// serveEntry writes a cached or freshly loaded response with ETag,
+// Cache-Control, Age and Vary headers, answering conditional requests
+// with 304 Not Modified.
+func serveEntry[T any](w http.ResponseWriter, r *http.Request, e cache.Entry[T], policy HTTPPolicy, status int, header http.Header, body []byte) {
+	h := w.Header()
+	for k, v := range header {
+		h[k] = v
+	}
+
+	etag := h.Get("ETag")
+	if etag == "" {
+		sum := sha256.Sum256(body)
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+		h.Set("ETag", etag)
+	}
+
+	h.Set(CacheHeader, string(e.State))
+	h.Set("Cache-Control", cacheControl(e, policy))
+	if e.State != cache.Miss && !e.StoredAt.IsZero() {
+		h.Set("Age", strconv.Itoa(int(time.Since(e.StoredAt).Seconds())))
+	}
+	for _, v := range policy.Vary {
+		h.Add("Vary", v)
+	}
+
+	if status == http.StatusOK && etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+func cacheControl[T any](e cache.Entry[T], policy HTTPPolicy) string {
+	maxAge := policy.TTL
+	if !e.FreshUntil.IsZero() {
+		maxAge = time.Until(e.FreshUntil)
+	}
+	if maxAge < 0 {
+		maxAge = 0
+	}
+
+	cc := "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	if policy.Stale > 0 {
+		cc += ", stale-while-revalidate=" + strconv.Itoa(int(policy.Stale.Seconds()))
+	}
+	return cc
+}
+
+// etagMatch implements the weak comparison If-None-Match calls for.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseCache caches the status, headers and body of any GET handler and
+// answers HEAD requests from the same entries. Only 200 responses without
+// Cache-Control: no-store or private are stored; concurrent misses for the
+// same URL share one handler call unless its response is not stored.
+func ResponseCache(responses *cache.Cache[Response], keys *storage.Namespace, policy HTTPPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, err := keys.Key(r.Context(), responseKey(r, policy.Vary))
+			if err != nil {
+				log.Printf("response cache: key: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// a HEAD miss loads the full GET response, which has the body
+			// later GETs of the same entry need
+			replay := r.Clone(detach(r.Context()))
+			replay.Method = http.MethodGet
+			load := func(ctx context.Context) (Response, error) {
+				resp := capture(next, replay)
+				if !cacheable(resp) {
+					return resp, &uncacheable{resp: resp, req: replay}
+				}
+				return resp, nil
+			}
+
+			e, err := responses.Fetch(r.Context(), key, policy.TTL, load)
+			var skip *uncacheable
+			if errors.As(err, &skip) {
+				// a private response is only for the request that made it;
+				// callers that joined its load run the handler themselves
+				if skip.req == replay {
+					writeResponse(w, r, skip.resp)
+				} else {
+					next.ServeHTTP(w, r)
+				}
+				return
+			}
+			if err != nil {
+				log.Printf("response cache: %s: %v", key, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			serveEntry(w, r, e, policy, e.Value.Status, e.Value.Header, e.Value.Body)
+		})
+	}
+}
+
+func responseKey(r *http.Request, vary []string) string {
+	key := r.URL.RequestURI()
+	for _, h := range vary {
+		key += "|" + strings.ToLower(h) + "=" + r.Header.Get(h)
+	}
+	return key
+}
+
+// detach lets a replayed request outlive the one that triggered it. chi
+// recycles its route context once the original request is done, so the
+// replay gets its own copy.
+func detach(ctx context.Context) context.Context {
+	ctx = context.WithoutCancel(ctx)
+
+	rctx := chi.RouteContext(ctx)
+	if rctx == nil {
+		return ctx
+	}
+
+	cp := chi.NewRouteContext()
+	cp.Routes = rctx.Routes
+	cp.RoutePath = rctx.RoutePath
+	cp.RouteMethod = rctx.RouteMethod
+	cp.URLParams.Keys = append(cp.URLParams.Keys, rctx.URLParams.Keys...)
+	cp.URLParams.Values = append(cp.URLParams.Values, rctx.URLParams.Values...)
+	cp.RoutePatterns = append(cp.RoutePatterns, rctx.RoutePatterns...)
+	return context.WithValue(ctx, chi.RouteCtxKey, cp)
+}
+
+func capture(next http.Handler, r *http.Request) Response {
+	cw := &captureWriter{header: make(http.Header)}
+	next.ServeHTTP(cw, r)
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	return Response{Status: cw.status, Header: cw.header, Body: cw.body.Bytes()}
+}
+
+func writeResponse(w http.ResponseWriter, r *http.Request, resp Response) {
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.Status)
+	if r.Method != http.MethodHead {
+		w.Write(resp.Body)
+	}
+}
+
+func cacheable(resp Response) bool {
+	if resp.Status != http.StatusOK {
+		return false
+	}
+
+	cc := strings.ToLower(resp.Header.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
+
+func (c *captureWriter) Header() http.Header {
+	return c.header
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.body.Write(b)
+}
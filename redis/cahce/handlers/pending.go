@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPending caps the cards remembered for a later invalidation; past
+	// it the whole card namespace is bumped instead.
+	maxPending   = 10000
+	pendingRetry = time.Second
+)
+
+// pending remembers the cards whose cache entries could not be invalidated
+// or tagged, or that could not be added to the bloom filter, e.g. while the
+// breaker was open, along with their tags.
+type pending struct {
+	mu       sync.Mutex
+	cards    map[int][]string
+	overflow bool
+}
+
+func (p *pending) add(id int, tags ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.overflow {
+		return
+	}
+	if p.cards == nil {
+		p.cards = make(map[int][]string)
+	}
+	if _, ok := p.cards[id]; !ok && len(p.cards) >= maxPending {
+		p.cards, p.overflow = nil, true
+		return
+	}
+	p.cards[id] = append(p.cards[id], tags...)
+}
+
+// take empties p, returning what it held.
+func (p *pending) take() (map[int][]string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cards, overflow := p.cards, p.overflow
+	p.cards, p.overflow = nil, false
+	return cards, overflow
+}
+
+func (p *pending) setOverflow() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cards, p.overflow = nil, true
+}
+
+// retryPending invalidates the cards the store failed to once redis takes
+// commands again, until ctx is done.
+func (s *CardStore) retryPending(ctx context.Context) {
+	ticker := time.NewTicker(pendingRetry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.bypass() {
+			s.flushPending(ctx)
+		}
+	}
+}
+
+// flushPending adds the pending cards to the bloom filter, invalidates
+// and re-tags them, or bumps the namespace and reseeds the filter when
+// there were too many. Whatever fails stays pending.
+func (s *CardStore) flushPending(ctx context.Context) {
+	cards, overflow := s.pending.take()
+	if overflow {
+		if _, err := s.keys.Bump(ctx); err != nil {
+			log.Printf("cards: bump namespace: %v", err)
+			s.pending.setOverflow()
+			return
+		}
+		if s.bloom != nil {
+			if err := SeedBloom(ctx, s.bloom, s.repo); err != nil {
+				log.Printf("cards: seed bloom: %v", err)
+				s.pending.setOverflow()
+			}
+		}
+		return
+	}
+	if len(cards) == 0 {
+		return
+	}
+
+	if s.bloom != nil {
+		ids := make([]string, 0, len(cards))
+		for id := range cards {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		if err := s.bloom.Add(ctx, ids...); err != nil {
+			log.Printf("cards: bloom %d pending: %v", len(ids), err)
+			for id, tags := range cards {
+				s.pending.add(id, tags...)
+			}
+			return
+		}
+	}
+
+	keys := make(map[int]string, len(cards))
+	for id, tags := range cards {
+		key, err := s.Key(ctx, id)
+		if err != nil {
+			log.Printf("card %d: key: %v", id, err)
+			s.pending.add(id, tags...)
+			continue
+		}
+		keys[id] = key
+	}
+
+	all := make([]string, 0, len(keys))
+	for _, key := range keys {
+		all = append(all, key)
+	}
+	if err := s.cards.Invalidate(ctx, all...); err != nil {
+		log.Printf("cards: invalidate %d pending: %v", len(all), err)
+		for id := range keys {
+			s.pending.add(id, cards[id]...)
+		}
+		return
+	}
+
+	for id, key := range keys {
+		if err := s.cards.Tag(ctx, key, s.ttl.TTL, cards[id]...); err != nil {
+			log.Printf("card %d: tag: %v", id, err)
+			s.pending.add(id, cards[id]...)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// PostgresCardRepository stores cards in the cards table.
+type PostgresCardRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresCardRepository(db *sql.DB) *PostgresCardRepository {
+	return &PostgresCardRepository{db: db}
+}
+
+// Migrate creates the cards table if it does not exist yet.
+func (p *PostgresCardRepository) Migrate(ctx context.Context) error {
+	_, err := p.db.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS cards (
+            id INT PRIMARY KEY,
+            name TEXT NOT NULL,
+            data TEXT NOT NULL
+        )
+    `)
+	return err
+}
+
+func (p *PostgresCardRepository) Get(ctx context.Context, id int) (Card, error) {
+	var card Card
+	err := p.db.QueryRowContext(ctx, "SELECT id, name, data FROM cards WHERE id = $1", id).
+		Scan(&card.ID, &card.Name, &card.Data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Card{}, ErrCardNotFound
+	}
+	return card, err
+}
+
+func (p *PostgresCardRepository) GetMany(ctx context.Context, ids []int) (map[int]Card, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT id, name, data FROM cards WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cards := make(map[int]Card, len(ids))
+	for rows.Next() {
+		var card Card
+		if err := rows.Scan(&card.ID, &card.Name, &card.Data); err != nil {
+			return nil, err
+		}
+		cards[card.ID] = card
+	}
+	return cards, rows.Err()
+}
+
+func (p *PostgresCardRepository) Save(ctx context.Context, card Card) error {
+	_, err := p.db.ExecContext(ctx, `
+        INSERT INTO cards (id, name, data) VALUES ($1, $2, $3)
+        ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, data = EXCLUDED.data
+    `, card.ID, card.Name, card.Data)
+	return err
+}
+
+func (p *PostgresCardRepository) Delete(ctx context.Context, id int) error {
+	res, err := p.db.ExecContext(ctx, "DELETE FROM cards WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCardNotFound
+	}
+	return nil
+}
+
+func (p *PostgresCardRepository) IDs(ctx context.Context) ([]int, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT id FROM cards")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
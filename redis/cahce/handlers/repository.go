@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrCardNotFound = errors.New("card not found")
+
+type (
+	// CardRepository is the origin cards are cached from.
+	CardRepository interface {
+		Get(ctx context.Context, id int) (Card, error)
+		// GetMany returns the cards found among ids, keyed by id.
+		GetMany(ctx context.Context, ids []int) (map[int]Card, error)
+		Save(ctx context.Context, card Card) error
+		Delete(ctx context.Context, id int) error
+		// IDs lists every stored card id.
+		IDs(ctx context.Context) ([]int, error)
+	}
+
+	// MemoryCardRepository keeps cards in a map, for tests and local runs.
+	MemoryCardRepository struct {
+		mu    sync.RWMutex
+		cards map[int]Card
+	}
+)
+
+func NewMemoryCardRepository(cards ...Card) *MemoryCardRepository {
+	repo := &MemoryCardRepository{cards: make(map[int]Card, len(cards))}
+	for _, card := range cards {
+		repo.cards[card.ID] = card
+	}
+	return repo
+}
+
+func (m *MemoryCardRepository) Get(ctx context.Context, id int) (Card, error) {
+	if err := ctx.Err(); err != nil {
+		return Card{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	card, ok := m.cards[id]
+	if !ok {
+		return Card{}, ErrCardNotFound
+	}
+	return card, nil
+}
+
+func (m *MemoryCardRepository) GetMany(ctx context.Context, ids []int) (map[int]Card, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cards := make(map[int]Card, len(ids))
+	for _, id := range ids {
+		if card, ok := m.cards[id]; ok {
+			cards[id] = card
+		}
+	}
+	return cards, nil
+}
+
+func (m *MemoryCardRepository) Save(ctx context.Context, card Card) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cards[card.ID] = card
+	return nil
+}
+
+func (m *MemoryCardRepository) Delete(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.cards[id]; !ok {
+		return ErrCardNotFound
+	}
+	delete(m.cards, id)
+	return nil
+}
+
+func (m *MemoryCardRepository) IDs(ctx context.Context) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]int, 0, len(m.cards))
+	for id := range m.cards {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
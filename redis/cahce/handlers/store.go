@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"my-go-app/redis/cahce/cache"
+	"my-go-app/redis/cahce/storage"
+)
+
+type (
+	// CardStore reads cards through the cache and writes them to the
+	// origin, keeping the cache in line according to its WriteMode.
+	CardStore struct {
+		cards   *cache.Cache[Card]
+		keys    *storage.Namespace
+		breaker *storage.Breaker
+		bloom   *storage.Bloom
+		repo    CardRepository
+		mode    WriteMode
+		ttl     storage.TTLPolicy
+		// pending holds cards to invalidate once redis is back.
+		pending pending
+	}
+
+	CardStoreConfig struct {
+		// Breaker lets the store skip redis while it is down. Nil never
+		// bypasses the cache.
+		Breaker *storage.Breaker
+		// Bloom rejects ids that were never written. Nil lets every id
+		// through.
+		Bloom *storage.Bloom
+		Mode  WriteMode
+		// TTL defaults to 30s with up to 3s of jitter.
+		TTL storage.TTLPolicy
+	}
+)
+
+// Namespace is the key namespace of cached cards.
+func (s *CardStore) Namespace() *storage.Namespace {
+	return s.keys
+}
+
+func (s *CardStore) Key(ctx context.Context, id int) (string, error) {
+	return s.keys.Key(ctx, strconv.Itoa(id))
+}
+
+// Lookup returns the cached card without consulting the origin.
+func (s *CardStore) Lookup(ctx context.Context, id int) (cache.Entry[Card], error) {
+	if s.bypass() {
+		return cache.Entry[Card]{State: cache.Miss}, storage.ErrBreakerOpen
+	}
+
+	key, err := s.Key(ctx, id)
+	if err != nil {
+		return cache.Entry[Card]{State: cache.Miss}, err
+	}
+	return s.cards.LookupEntry(ctx, key)
+}
+
+// Fetch returns the card from the cache, loading it from the origin on a
+// miss or while the cache is unavailable.
+func (s *CardStore) Fetch(ctx context.Context, id int) (cache.Entry[Card], error) {
+	if s.bypass() {
+		card, err := s.repo.Get(ctx, id)
+		return cache.Entry[Card]{Value: card, State: cache.Miss}, err
+	}
+
+	key, err := s.Key(ctx, id)
+	if err != nil {
+		return cache.Entry[Card]{State: cache.Miss}, err
+	}
+
+	e, err := s.cards.Fetch(ctx, key, s.ttl.TTL, s.loader(id))
+	if errors.Is(err, cache.ErrNotFound) {
+		return e, ErrCardNotFound
+	}
+	return e, err
+}
+
+// FetchMany is Fetch for several cards. Cached cards are read in one round
+// trip and the rest are loaded from the origin in one batch. It returns an
+// entry and an error for every id, in order.
+func (s *CardStore) FetchMany(ctx context.Context, ids []int) ([]cache.Entry[Card], []error) {
+	entries := make([]cache.Entry[Card], len(ids))
+	errs := make([]error, len(ids))
+
+	if s.bypass() {
+		cards, err := s.repo.GetMany(ctx, ids)
+		for i, id := range ids {
+			card, ok := cards[id]
+			switch {
+			case err != nil:
+				errs[i] = err
+			case !ok:
+				errs[i] = ErrCardNotFound
+			}
+			entries[i] = cache.Entry[Card]{Value: card, State: cache.Miss}
+		}
+		return entries, errs
+	}
+
+	keys := make([]string, len(ids))
+	byKey := make(map[string]int, len(ids))
+	for i, id := range ids {
+		key, err := s.Key(ctx, id)
+		if err != nil {
+			for i := range errs {
+				entries[i], errs[i] = cache.Entry[Card]{State: cache.Miss}, err
+			}
+			return entries, errs
+		}
+		keys[i], byKey[key] = key, id
+	}
+
+	load := func(ctx context.Context, keys []string) (map[string]Card, error) {
+		missing := make([]int, len(keys))
+		for i, key := range keys {
+			missing[i] = byKey[key]
+		}
+
+		cards, err := s.repo.GetMany(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make(map[string]Card, len(cards))
+		for _, key := range keys {
+			if card, ok := cards[byKey[key]]; ok {
+				values[key] = card
+			}
+		}
+		return values, nil
+	}
+
+	entries, errs = s.cards.FetchMany(ctx, keys, s.ttl.TTL, load)
+	for i, e := range entries {
+		if errors.Is(errs[i], cache.ErrNotFound) {
+			errs[i] = ErrCardNotFound
+		}
+		if errs[i] == nil && e.State == cache.Stale {
+			s.cards.Refresh(ctx, keys[i], s.ttl.TTL, s.loader(ids[i]))
+		}
+	}
+	return entries, errs
+}
+
+// MightExist reports false only for ids that were never written through
+// the store or seeded; cards saved to the origin by anything else stay
+// rejected until the next SeedBloom. Without a bloom filter every id
+// might exist.
+func (s *CardStore) MightExist(ctx context.Context, id int) bool {
+	if s.bloom == nil || s.bypass() {
+		return true
+	}
+	return s.bloom.MightContain(ctx, strconv.Itoa(id))
+}
+
+// Refresh reloads the card in the background.
+func (s *CardStore) Refresh(ctx context.Context, id int) {
+	key, err := s.Key(ctx, id)
+	if err != nil {
+		log.Printf("card %d: refresh: %v", id, err)
+		return
+	}
+	s.cards.Refresh(ctx, key, s.ttl.TTL, s.loader(id))
+}
+
+// Write saves the card to the origin, then writes it through to the cache
+// or invalidates it. The cached card is added to tags, e.g. "user:42", for
+// InvalidateTag. Cache and bloom filter updates that fail are retried in
+// the background until redis is back.
+func (s *CardStore) Write(ctx context.Context, card Card, tags ...string) error {
+	if err := s.repo.Save(ctx, card); err != nil {
+		return err
+	}
+
+	if s.bloom != nil {
+		if err := s.bloom.Add(ctx, strconv.Itoa(card.ID)); err != nil {
+			// the filter would reject the card until it is added
+			log.Printf("card %d: bloom: %v", card.ID, err)
+			s.pending.add(card.ID, tags...)
+		}
+	}
+
+	key, err := s.Key(ctx, card.ID)
+	if err != nil {
+		log.Printf("card %d: key: %v", card.ID, err)
+		s.pending.add(card.ID, tags...)
+		return nil
+	}
+
+	mode := s.mode
+	if mode == WriteThrough {
+		if err := s.cards.SetTagged(ctx, key, card, s.ttl.TTL, tags...); err != nil {
+			log.Printf("card %d: write-through: %v", card.ID, err)
+			// a failed write-through must not leave the old card cached
+			mode = WriteInvalidate
+		} else if err := s.cards.Publish(ctx, key); err != nil {
+			log.Printf("card %d: publish: %v", card.ID, err)
+			s.pending.add(card.ID)
+		}
+	}
+	if mode == WriteInvalidate {
+		if err := s.cards.Invalidate(ctx, key); err != nil {
+			log.Printf("card %d: invalidate: %v", card.ID, err)
+			s.pending.add(card.ID, tags...)
+		} else if err := s.cards.Tag(ctx, key, s.ttl.TTL, tags...); err != nil {
+			// tagged after the invalidation, which would drop the tags
+			// again; the next load keeps them
+			log.Printf("card %d: tag: %v", card.ID, err)
+			s.pending.add(card.ID, tags...)
+		}
+	}
+	return nil
+}
+
+// InvalidateTag drops every cached card written with tag. The cards stay
+// in the origin.
+func (s *CardStore) InvalidateTag(ctx context.Context, tag string) error {
+	return s.cards.InvalidateTag(ctx, tag)
+}
+
+// Delete removes the card from the origin and every cache tier.
+func (s *CardStore) Delete(ctx context.Context, id int) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	key, err := s.Key(ctx, id)
+	if err == nil {
+		err = s.cards.Invalidate(ctx, key)
+	}
+	if err != nil {
+		log.Printf("card %d: invalidate: %v", id, err)
+		s.pending.add(id)
+	}
+	return nil
+}
+
+func (s *CardStore) Stats() cache.Stats {
+	return s.cards.Stats()
+}
+
+// bypass tells whether redis is known to be down, in which case the
+// origin is used directly.
+func (s *CardStore) bypass() bool {
+	return s.breaker != nil && s.breaker.Open()
+}
+
+func (s *CardStore) loader(id int) cache.LoadFunc[Card] {
+	return func(ctx context.Context) (Card, error) {
+		card, err := s.repo.Get(ctx, id)
+		if errors.Is(err, ErrCardNotFound) {
+			// let the cache remember the card is missing
+			return card, fmt.Errorf("%w: %w", cache.ErrNotFound, err)
+		}
+		return card, err
+	}
+}
+
+// SeedBloom adds every card id in repo to bloom, so ids written before the
+// filter existed are not rejected.
+func SeedBloom(ctx context.Context, bloom *storage.Bloom, repo CardRepository) error {
+	ids, err := repo.IDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	items := make([]string, len(ids))
+	for i, id := range ids {
+		items[i] = strconv.Itoa(id)
+	}
+	return bloom.Add(ctx, items...)
+}
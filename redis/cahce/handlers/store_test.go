@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"my-go-app/redis/cahce/cache"
+	"my-go-app/redis/cahce/storage"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestStore returns a store over a repository holding cards, in front
+// of a redis that cannot be reached. Its breaker is open, so the store
+// works against the repository alone.
+func newTestStore(t *testing.T, cards ...Card) (*CardStore, *MemoryCardRepository) {
+	t.Helper()
+
+	db := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+	t.Cleanup(func() { db.Close() })
+
+	breaker := storage.NewBreaker("test", storage.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour})
+	db.AddHook(breaker)
+	db.Ping(context.Background())
+	if !breaker.Open() {
+		t.Fatal("breaker did not open")
+	}
+
+	repo := NewMemoryCardRepository(cards...)
+	store := NewCardStore(t.Context(), db, repo, CardStoreConfig{Breaker: breaker, Mode: WriteInvalidate})
+	return store, repo
+}
+
+func TestCardStoreFetch(t *testing.T) {
+	store, _ := newTestStore(t, Card{ID: 1, Name: "one"})
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		id      int
+		want    Card
+		wantErr error
+	}{
+		{name: "found", ctx: context.Background(), id: 1, want: Card{ID: 1, Name: "one"}},
+		{name: "missing", ctx: context.Background(), id: 2, wantErr: ErrCardNotFound},
+		{name: "cancelled", ctx: cancelled, id: 1, wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := store.Fetch(tt.ctx, tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Fetch(%d) error = %v, want %v", tt.id, err, tt.wantErr)
+			}
+			if err == nil && e.Value != tt.want {
+				t.Errorf("Fetch(%d) = %+v, want %+v", tt.id, e.Value, tt.want)
+			}
+			if e.State != cache.Miss {
+				t.Errorf("Fetch(%d) state = %s, want %s", tt.id, e.State, cache.Miss)
+			}
+		})
+	}
+}
+
+func TestCardStoreFetchMany(t *testing.T) {
+	store, _ := newTestStore(t, Card{ID: 1, Name: "one"}, Card{ID: 3, Name: "three"})
+
+	entries, errs := store.FetchMany(context.Background(), []int{1, 2, 3})
+	wantErrs := []error{nil, ErrCardNotFound, nil}
+	for i, want := range wantErrs {
+		if !errors.Is(errs[i], want) {
+			t.Errorf("card %d: error = %v, want %v", i+1, errs[i], want)
+		}
+	}
+	if entries[0].Value.Name != "one" || entries[2].Value.Name != "three" {
+		t.Errorf("FetchMany = %+v", entries)
+	}
+}
+
+func TestCardStoreWriteDelete(t *testing.T) {
+	store, repo := newTestStore(t)
+	ctx := context.Background()
+
+	card := Card{ID: 7, Name: "seven"}
+	if err := store.Write(ctx, card, "user:1"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got, err := repo.Get(ctx, 7); err != nil || got != card {
+		t.Fatalf("origin has %+v, %v after Write, want %+v", got, err, card)
+	}
+	if _, ok := store.pending.cards[7]; !ok {
+		t.Error("card the cache could not be told about is not pending")
+	}
+
+	if err := store.Delete(ctx, 7); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Fetch(ctx, 7); !errors.Is(err, ErrCardNotFound) {
+		t.Errorf("Fetch after Delete error = %v, want %v", err, ErrCardNotFound)
+	}
+	if err := store.Delete(ctx, 7); !errors.Is(err, ErrCardNotFound) {
+		t.Errorf("second Delete error = %v, want %v", err, ErrCardNotFound)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.Write(cancelled, card); !errors.Is(err, context.Canceled) {
+		t.Errorf("Write with cancelled context error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPendingOverflow(t *testing.T) {
+	var p pending
+	for id := 0; id < maxPending; id++ {
+		p.add(id)
+	}
+	p.add(0, "user:1")
+	if cards, overflow := p.take(); overflow || len(cards) != maxPending {
+		t.Fatalf("take() = %d cards, overflow %v; want %d, false", len(cards), overflow, maxPending)
+	}
+
+	for id := 0; id <= maxPending; id++ {
+		p.add(id)
+	}
+	if cards, overflow := p.take(); !overflow || cards != nil {
+		t.Fatalf("take() = %d cards, overflow %v; want none, true", len(cards), overflow)
+	}
+	if cards, overflow := p.take(); overflow || cards != nil {
+		t.Errorf("take() after take() = %d cards, overflow %v; want none, false", len(cards), overflow)
+	}
+}
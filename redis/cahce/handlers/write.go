@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+const (
+	// WriteThrough stores the new card in redis after writing the origin.
+	WriteThrough WriteMode = iota
+	// WriteInvalidate drops the cached card and lets the next read reload it.
+	WriteInvalidate
+)
+
+type (
+	WriteMode int
+)
+
+// PutCard replaces a card with the request body. The tags query parameter,
+// e.g. ?tags=user:42,collection:7, tags the cached card.
+func PutCard(store *CardStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := cardID(w, r)
+		if !ok {
+			return
+		}
+
+		var card Card
+		if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
+		}
+		card.ID = id
+
+		writeCard(w, r, store, card)
+	}
+}
+
+// PatchCard merges the fields present in the request body into the card
+// as stored at the origin. Tags are taken as in PutCard.
+func PatchCard(store *CardStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := cardID(w, r)
+		if !ok {
+			return
+		}
+
+		// the cache may hold an older card, and writing the merge back
+		// would undo whatever changed since
+		card, err := store.repo.Get(r.Context(), id)
+		if errors.Is(err, ErrCardNotFound) {
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
+		}
+		card.ID = id
+
+		writeCard(w, r, store, card)
+	}
+}
+
+func DeleteCard(store *CardStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := cardID(w, r)
+		if !ok {
+			return
+		}
+
+		err := store.Delete(r.Context(), id)
+		if errors.Is(err, ErrCardNotFound) {
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// InvalidateCardTag drops every cached card tagged with {tag}.
+func InvalidateCardTag(store *CardStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag := chi.URLParam(r, "tag")
+		if tag == "" {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, errorResponse{Error: "tag is required"})
+			return
+		}
+
+		if err := store.InvalidateTag(r.Context(), tag); err != nil {
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, errorResponse{Error: err.Error()})
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeCard(w http.ResponseWriter, r *http.Request, store *CardStore, card Card) {
+	if err := store.Write(r.Context(), card, cardTags(r)...); err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, errorResponse{Error: err.Error()})
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, card)
+}
+
+func cardTags(r *http.Request) []string {
+	var tags []string
+	for _, tag := range strings.Split(r.URL.Query().Get("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func cardID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, errorResponse{Error: "invalid card id"})
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func newTestRouter(t *testing.T, cards ...Card) (http.Handler, *MemoryCardRepository) {
+	t.Helper()
+
+	store, repo := newTestStore(t, cards...)
+	router := chi.NewRouter()
+	router.Route("/card", NewCardHandler(store))
+	return router, repo
+}
+
+func TestCardHandlers(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		want       *Card
+	}{
+		{name: "get", method: http.MethodGet, path: "/card/1", wantStatus: http.StatusOK, want: &Card{ID: 1, Name: "one", Data: "a"}},
+		{name: "get missing", method: http.MethodGet, path: "/card/2", wantStatus: http.StatusNotFound},
+		{name: "get invalid id", method: http.MethodGet, path: "/card/x", wantStatus: http.StatusBadRequest},
+		{name: "put", method: http.MethodPut, path: "/card/2", body: `{"name":"two"}`, wantStatus: http.StatusOK, want: &Card{ID: 2, Name: "two"}},
+		{name: "put invalid body", method: http.MethodPut, path: "/card/2", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "patch", method: http.MethodPatch, path: "/card/1", body: `{"data":"b"}`, wantStatus: http.StatusOK, want: &Card{ID: 1, Name: "one", Data: "b"}},
+		{name: "patch missing", method: http.MethodPatch, path: "/card/2", body: `{"data":"b"}`, wantStatus: http.StatusNotFound},
+		{name: "delete", method: http.MethodDelete, path: "/card/1", wantStatus: http.StatusNoContent},
+		{name: "delete missing", method: http.MethodDelete, path: "/card/2", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, repo := newTestRouter(t, Card{ID: 1, Name: "one", Data: "a"})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.want == nil {
+				return
+			}
+
+			var got Card
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decoding %s: %v", rec.Body, err)
+			}
+			if got != *tt.want {
+				t.Errorf("response = %+v, want %+v", got, *tt.want)
+			}
+			if stored, err := repo.Get(context.Background(), got.ID); err != nil || stored != *tt.want {
+				t.Errorf("origin has %+v, %v; want %+v", stored, err, *tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteCardRemovesIt(t *testing.T) {
+	router, _ := newTestRouter(t, Card{ID: 1, Name: "one"})
+
+	for _, tt := range []struct {
+		method string
+		want   int
+	}{
+		{http.MethodDelete, http.StatusNoContent},
+		{http.MethodGet, http.StatusNotFound},
+		{http.MethodPatch, http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(tt.method, "/card/1", strings.NewReader(`{}`)))
+		if rec.Code != tt.want {
+			t.Errorf("%s status = %d, want %d", tt.method, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestGetCardCancelled(t *testing.T) {
+	router, _ := newTestRouter(t, Card{ID: 1, Name: "one"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/card/1", nil).WithContext(ctx))
+	if rec.Body.Len() != 0 {
+		t.Errorf("cancelled request got a body: %s", rec.Body)
+	}
+}
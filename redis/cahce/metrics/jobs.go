@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Job results.
+const (
+	JobDone    = "done"
+	JobRetried = "retried"
+	JobDead    = "dead"
+)
+
+var (
+	jobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "queue_jobs_total",
+		Help: "Jobs processed by stream, type and result: done, retried or dead",
+	}, []string{"stream", "type", "result"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "queue_job_duration_seconds",
+		Help: "Duration of job handlers",
+	}, []string{"stream", "type"})
+)
+
+func ObserveJob(stream, typ, result string, d time.Duration) {
+	jobsTotal.WithLabelValues(stream, typ, result).Inc()
+	if d > 0 {
+		jobDuration.WithLabelValues(stream, typ).Observe(d.Seconds())
+	}
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+type labelKey int
+
+const (
+	routeKey labelKey = iota
+	namespaceKey
+)
+
+// WithRoute labels metrics recorded with ctx by route.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey, route)
+}
+
+// WithNamespace labels metrics recorded with ctx by key namespace.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey, namespace)
+}
+
+// Route labels the request's metrics with its chi route pattern. It must
+// be attached at endpoint level, e.g. r.With(metrics.Route).Get(...),
+// where the pattern is complete.
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		next.ServeHTTP(w, r.WithContext(WithRoute(r.Context(), route)))
+	})
+}
+
+func labels(ctx context.Context) (route, namespace string) {
+	route, _ = ctx.Value(routeKey).(string)
+	namespace, _ = ctx.Value(namespaceKey).(string)
+	return route, namespace
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	Hit      = "hit"
+	Stale    = "stale"
+	Miss     = "miss"
+	Negative = "negative"
+	Error    = "error"
+)
+
+var (
+	lookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_lookups_total",
+		Help: "Cache lookups by result: hit, stale, miss, negative or error",
+	}, []string{"route", "namespace", "result"})
+
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cache_redis_duration_seconds",
+		Help:    "Duration of redis commands and pipelines",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"route", "namespace", "command"})
+
+	loadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "cache_load_duration_seconds",
+		Help: "Duration of origin loads on cache misses",
+	}, []string{"route", "namespace"})
+
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "redis_breaker_state",
+		Help: "Circuit breaker state: 0 closed, 1 half-open, 2 open",
+	}, []string{"name"})
+
+	entrySize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cache_entry_size_bytes",
+		Help:    "Size of entries written to the cache",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"route", "namespace"})
+)
+
+type startKey struct{}
+
+func ObserveLookup(ctx context.Context, result string) {
+	route, namespace := labels(ctx)
+	lookupsTotal.WithLabelValues(route, namespace, result).Inc()
+}
+
+func ObserveLoad(ctx context.Context, d time.Duration) {
+	route, namespace := labels(ctx)
+	loadDuration.WithLabelValues(route, namespace).Observe(d.Seconds())
+}
+
+func ObserveEntrySize(ctx context.Context, size int) {
+	route, namespace := labels(ctx)
+	entrySize.WithLabelValues(route, namespace).Observe(float64(size))
+}
+
+func SetBreakerState(name string, state int) {
+	breakerState.WithLabelValues(name).Set(float64(state))
+}
+
+// Hook times every command of the client it is added to.
+type Hook struct{}
+
+func (Hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (Hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeRedis(ctx, cmd.Name())
+	return nil
+}
+
+func (Hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (Hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	observeRedis(ctx, "pipeline")
+	return nil
+}
+
+func observeRedis(ctx context.Context, command string) {
+	start, ok := ctx.Value(startKey{}).(time.Time)
+	if !ok {
+		return
+	}
+	route, namespace := labels(ctx)
+	redisDuration.WithLabelValues(route, namespace, command).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolHits     = poolDesc("redis_pool_hits_total", "Times a free connection was found in the pool")
+	poolMisses   = poolDesc("redis_pool_misses_total", "Times a free connection was not found in the pool")
+	poolTimeouts = poolDesc("redis_pool_timeouts_total", "Times a wait for a connection timed out")
+	poolTotal    = poolDesc("redis_pool_conns", "Connections in the pool")
+	poolIdle     = poolDesc("redis_pool_idle_conns", "Idle connections in the pool")
+	poolStale    = poolDesc("redis_pool_stale_conns_total", "Stale connections removed from the pool")
+)
+
+// poolCollector reads PoolStats on every scrape.
+type poolCollector struct {
+	db redis.UniversalClient
+}
+
+func poolDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(name, help, nil, nil)
+}
+
+// RegisterPool exports the connection pool stats of db labelled by name.
+// Registering the same name twice is a no-op.
+func RegisterPool(name string, db redis.UniversalClient) error {
+	c := prometheus.WrapCollectorWith(prometheus.Labels{"client": name}, &poolCollector{db: db})
+
+	err := prometheus.Register(c)
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		return nil
+	}
+	return err
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{poolHits, poolMisses, poolTimeouts, poolTotal, poolIdle, poolStale} {
+		ch <- d
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.db.PoolStats()
+
+	ch <- prometheus.MustNewConstMetric(poolHits, prometheus.CounterValue, float64(s.Hits))
+	ch <- prometheus.MustNewConstMetric(poolMisses, prometheus.CounterValue, float64(s.Misses))
+	ch <- prometheus.MustNewConstMetric(poolTimeouts, prometheus.CounterValue, float64(s.Timeouts))
+	ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(s.TotalConns))
+	ch <- prometheus.MustNewConstMetric(poolIdle, prometheus.GaugeValue, float64(s.IdleConns))
+	ch <- prometheus.MustNewConstMetric(poolStale, prometheus.CounterValue, float64(s.StaleConns))
+}
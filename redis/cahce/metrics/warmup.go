@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Warm-up results.
+const (
+	WarmLoaded  = "loaded"
+	WarmCached  = "cached"
+	WarmMissing = "missing"
+	WarmFailed  = "failed"
+)
+
+var warmupItems = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cache_warmup_items_total",
+	Help: "Ids processed by cache warm-up by source and result: loaded, cached, missing or failed",
+}, []string{"source", "result"})
+
+func ObserveWarmup(source, result string) {
+	warmupItems.WithLabelValues(source, result).Inc()
+}
@@ -0,0 +1,409 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"my-go-app/redis/cahce/storage"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// DropOldest discards the oldest buffered message to make room.
+	DropOldest Overflow = iota
+	// DropNewest discards the message that does not fit.
+	DropNewest
+	// Block waits for room, holding up delivery to every other handler.
+	Block
+)
+
+const (
+	defaultBuffer       = 100
+	defaultPingInterval = 30 * time.Second
+	maxBackoff          = 5 * time.Second
+)
+
+var ErrClosed = errors.New("pubsub: bus is closed")
+
+type (
+	Overflow int
+
+	// Envelope is the JSON form of every published message.
+	Envelope struct {
+		ID   string          `json:"id"`
+		Type string          `json:"type"`
+		Time time.Time       `json:"time"`
+		Data json.RawMessage `json:"data"`
+	}
+
+	// Message is a received envelope with its data decoded. Pattern is set
+	// for messages received through PSubscribe.
+	Message[T any] struct {
+		Channel string
+		Pattern string
+		ID      string
+		Type    string
+		Time    time.Time
+		Data    T
+	}
+
+	Config struct {
+		// PingInterval is how long the connection may stay silent before
+		// it is pinged to detect that it is gone. Defaults to 30s.
+		PingInterval time.Duration `yaml:"ping_interval"`
+	}
+
+	// Options bound a handler's buffer and say what happens when it is
+	// full. Buffer defaults to 100 messages.
+	Options struct {
+		Buffer   int
+		Overflow Overflow
+	}
+
+	// Bus multiplexes any number of handlers over one redis subscription,
+	// which is restored after reconnects. Every handler runs in its own
+	// goroutine behind a bounded buffer.
+	Bus struct {
+		db  redis.UniversalClient
+		cnf Config
+
+		mu     sync.Mutex
+		ps     *redis.PubSub
+		subs   map[topic][]*Subscription
+		closed bool
+	}
+
+	// Subscription is one registered handler.
+	Subscription struct {
+		bus      *Bus
+		topic    topic
+		overflow Overflow
+		buf      chan *redis.Message
+		handle   func(ctx context.Context, msg *redis.Message)
+		ctx      context.Context
+		cancel   context.CancelFunc
+		dropped  atomic.Int64
+	}
+
+	topic struct {
+		name    string
+		pattern bool
+	}
+)
+
+func New(db redis.UniversalClient, cnf Config) *Bus {
+	if cnf.PingInterval <= 0 {
+		cnf.PingInterval = defaultPingInterval
+	}
+	return &Bus{db: db, cnf: cnf, subs: make(map[topic][]*Subscription)}
+}
+
+// Publish sends v wrapped in an Envelope to channel.
+func (b *Bus) Publish(ctx context.Context, channel string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	id, err := storage.NewToken()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(Envelope{ID: id, Type: typeName(v), Time: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+	return b.db.Publish(ctx, channel, payload).Err()
+}
+
+// Subscribe calls handler with every message published to channel whose
+// data decodes into T. Handler errors and undecodable messages are logged.
+func Subscribe[T any](b *Bus, channel string, handler func(ctx context.Context, msg Message[T]) error, opts Options) (*Subscription, error) {
+	return b.subscribe(topic{name: channel}, decoder(handler), opts)
+}
+
+// PSubscribe is Subscribe for every channel matching a glob-style pattern.
+func PSubscribe[T any](b *Bus, pattern string, handler func(ctx context.Context, msg Message[T]) error, opts Options) (*Subscription, error) {
+	return b.subscribe(topic{name: pattern, pattern: true}, decoder(handler), opts)
+}
+
+// Run receives messages and hands them to the subscriptions until ctx is
+// done. Connection errors are retried with backoff; the redis client
+// restores every subscription when it reconnects.
+func (b *Bus) Run(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	if b.ps != nil {
+		b.mu.Unlock()
+		return errors.New("pubsub: bus is already running")
+	}
+	b.ps = b.db.Subscribe(ctx)
+	ps := b.ps
+	if err := b.subscribeAll(ctx, ps); err != nil {
+		// the subscriptions are remembered and sent again on reconnect
+		log.Printf("pubsub: subscribe: %v", err)
+	}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.ps = nil
+		b.mu.Unlock()
+		ps.Close()
+	}()
+
+	backoff := time.Duration(0)
+	for {
+		msg, err := ps.ReceiveTimeout(ctx, b.cnf.PingInterval)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// a silent connection may be dead; a ping finds out
+			if err := ps.Ping(ctx); err != nil {
+				log.Printf("pubsub: ping: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			backoff = min(max(2*backoff, 100*time.Millisecond), maxBackoff)
+			log.Printf("pubsub: receive: %v, retrying in %s", err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+		backoff = 0
+
+		switch msg := msg.(type) {
+		case *redis.Message:
+			b.dispatch(ctx, msg)
+		case *redis.Subscription:
+			log.Printf("pubsub: %s %s", msg.Kind, msg.Channel)
+		}
+	}
+}
+
+// Close stops every subscription's handler. Run returns once its ctx is
+// done.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, subs := range b.subs {
+		for _, s := range subs {
+			s.cancel()
+		}
+	}
+	b.subs = make(map[topic][]*Subscription)
+	return nil
+}
+
+// Unsubscribe stops the handler. Messages still buffered are discarded.
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	s.cancel()
+
+	b := s.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs := b.subs[s.topic]
+	for i, other := range subs {
+		if other == s {
+			subs = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) > 0 {
+		b.subs[s.topic] = subs
+		return nil
+	}
+
+	delete(b.subs, s.topic)
+	if b.ps == nil {
+		return nil
+	}
+	if s.topic.pattern {
+		return b.ps.PUnsubscribe(ctx, s.topic.name)
+	}
+	return b.ps.Unsubscribe(ctx, s.topic.name)
+}
+
+// Dropped is the number of messages discarded because the buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+func (b *Bus) subscribe(t topic, handle func(ctx context.Context, msg *redis.Message), opts Options) (*Subscription, error) {
+	if t.name == "" {
+		return nil, errors.New("pubsub: empty channel")
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultBuffer
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Subscription{
+		bus:      b,
+		topic:    t,
+		overflow: opts.Overflow,
+		buf:      make(chan *redis.Message, opts.Buffer),
+		handle:   handle,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		cancel()
+		return nil, ErrClosed
+	}
+
+	_, known := b.subs[t]
+	b.subs[t] = append(b.subs[t], s)
+	if !known && b.ps != nil {
+		var err error
+		if t.pattern {
+			err = b.ps.PSubscribe(ctx, t.name)
+		} else {
+			err = b.ps.Subscribe(ctx, t.name)
+		}
+		if err != nil {
+			// the subscription is remembered and sent again on reconnect
+			log.Printf("pubsub: subscribe %s: %v", t.name, err)
+		}
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// subscribeAll must be called with mu held.
+func (b *Bus) subscribeAll(ctx context.Context, ps *redis.PubSub) error {
+	var channels, patterns []string
+	for t := range b.subs {
+		if t.pattern {
+			patterns = append(patterns, t.name)
+		} else {
+			channels = append(channels, t.name)
+		}
+	}
+
+	if len(channels) > 0 {
+		if err := ps.Subscribe(ctx, channels...); err != nil {
+			return err
+		}
+	}
+	if len(patterns) > 0 {
+		return ps.PSubscribe(ctx, patterns...)
+	}
+	return nil
+}
+
+func (b *Bus) dispatch(ctx context.Context, msg *redis.Message) {
+	t := topic{name: msg.Channel}
+	if msg.Pattern != "" {
+		t = topic{name: msg.Pattern, pattern: true}
+	}
+
+	b.mu.Lock()
+	subs := append([]*Subscription(nil), b.subs[t]...)
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		s.deliver(ctx, msg)
+	}
+}
+
+func (s *Subscription) deliver(ctx context.Context, msg *redis.Message) {
+	switch s.overflow {
+	case Block:
+		select {
+		case s.buf <- msg:
+		case <-s.ctx.Done():
+		case <-ctx.Done():
+		}
+	case DropNewest:
+		select {
+		case s.buf <- msg:
+		default:
+			s.dropped.Add(1)
+		}
+	default:
+		for {
+			select {
+			case s.buf <- msg:
+				return
+			default:
+			}
+			select {
+			case <-s.buf:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+func (s *Subscription) run() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case msg := <-s.buf:
+			s.handle(s.ctx, msg)
+		}
+	}
+}
+
+func decoder[T any](handler func(ctx context.Context, msg Message[T]) error) func(ctx context.Context, msg *redis.Message) {
+	return func(ctx context.Context, raw *redis.Message) {
+		var env Envelope
+		if err := json.Unmarshal([]byte(raw.Payload), &env); err != nil {
+			log.Printf("pubsub: %s: malformed envelope: %v", raw.Channel, err)
+			return
+		}
+
+		msg := Message[T]{Channel: raw.Channel, Pattern: raw.Pattern, ID: env.ID, Type: env.Type, Time: env.Time}
+		if err := json.Unmarshal(env.Data, &msg.Data); err != nil {
+			log.Printf("pubsub: %s: message %s of type %s: %v", raw.Channel, env.ID, env.Type, err)
+			return
+		}
+
+		if err := handler(ctx, msg); err != nil {
+			log.Printf("pubsub: %s: message %s: %v", raw.Channel, env.ID, err)
+		}
+	}
+}
+
+func typeName(v any) string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	if t.PkgPath() == "" {
+		return t.String()
+	}
+	return fmt.Sprintf("%s.%s", t.PkgPath(), t.Name())
+}
@@ -0,0 +1,223 @@
+package queue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Schedule says when a recurring job runs next.
+	Schedule interface {
+		// Next is the first run after t, or the zero time if there is none.
+		Next(t time.Time) time.Time
+	}
+
+	// every runs at multiples of d since the zero time, so every instance
+	// computes the same runs.
+	every struct {
+		d time.Duration
+	}
+
+	cron struct {
+		minute, hour, dom, month, dow bits
+		// domStar and dowStar are set for an unrestricted "*" day field.
+		domStar, dowStar bool
+		loc              *time.Location
+	}
+
+	bits uint64
+)
+
+var (
+	descriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+
+	monthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+
+	dowNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// ParseSchedule parses "@every <duration>", a descriptor such as @hourly or
+// @daily, or a five field cron expression "minute hour day-of-month month
+// day-of-week" with lists, ranges, steps and month and weekday names.
+// Cron expressions use UTC unless prefixed with e.g. "TZ=Europe/Moscow ".
+// Runs in an hour skipped by a daylight saving change do not happen, runs
+// in a repeated hour happen twice.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("queue: schedule %q: %w", spec, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("queue: schedule %q: interval below 1s", spec)
+		}
+		return every{d: d}, nil
+	}
+
+	loc := time.UTC
+	expr := spec
+	if strings.HasPrefix(expr, "TZ=") {
+		name, rest, _ := strings.Cut(expr[len("TZ="):], " ")
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("queue: schedule %q: %w", spec, err)
+		}
+		expr = strings.TrimSpace(rest)
+	}
+	if d, ok := descriptors[expr]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("queue: schedule %q: want 5 fields, got %d", spec, len(fields))
+	}
+
+	c := &cron{loc: loc}
+	var err error
+	if c.minute, _, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("queue: schedule %q: minute: %w", spec, err)
+	}
+	if c.hour, _, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("queue: schedule %q: hour: %w", spec, err)
+	}
+	if c.dom, c.domStar, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("queue: schedule %q: day of month: %w", spec, err)
+	}
+	if c.month, _, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("queue: schedule %q: month: %w", spec, err)
+	}
+	if c.dow, c.dowStar, err = parseField(fields[4], 0, 7, dowNames); err != nil {
+		return nil, fmt.Errorf("queue: schedule %q: day of week: %w", spec, err)
+	}
+	// 7 is Sunday as well
+	if c.dow.has(7) {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Truncate(e.d).Add(e.d)
+}
+
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+	// an expression such as "0 0 30 2 *" never matches
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		y, m, d := t.Date()
+		var next time.Time
+		switch {
+		case !c.month.has(int(m)):
+			next = time.Date(y, m+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.day(t):
+			next = time.Date(y, m, d+1, 0, 0, 0, 0, c.loc)
+		case !c.hour.has(t.Hour()):
+			next = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, c.loc)
+		case !c.minute.has(t.Minute()):
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// time.Date moves wall times skipped by a daylight saving change
+		// backwards; the next whole hour always comes after t
+		if !next.After(t) {
+			next = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// day matches the day fields the way cron does: when both are restricted,
+// either one matching is enough.
+func (c *cron) day(t time.Time) bool {
+	dom, dow := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+	switch {
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func (b bits) has(i int) bool {
+	return b&(1<<uint(i)) != 0
+}
+
+// parseField parses a comma separated list of "*", "n" or "n-m", each
+// optionally followed by "/step". star reports a plain "*" or "?".
+func parseField(s string, lo, hi int, names map[string]int) (b bits, star bool, err error) {
+	if s == "*" || s == "?" {
+		star = true
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		span, step := part, 1
+		stepped := false
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("invalid step in %q", part)
+			}
+			span, stepped = part[:i], true
+		}
+
+		var from, to int
+		switch {
+		case span == "*" || span == "?":
+			from, to = lo, hi
+		case strings.Contains(span, "-"):
+			a, z, _ := strings.Cut(span, "-")
+			if from, err = value(a, names); err != nil {
+				return 0, false, err
+			}
+			if to, err = value(z, names); err != nil {
+				return 0, false, err
+			}
+		default:
+			if from, err = value(span, names); err != nil {
+				return 0, false, err
+			}
+			to = from
+			// "5/15" runs from 5 on
+			if stepped {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, false, fmt.Errorf("%q out of range %d-%d", part, lo, hi)
+		}
+
+		for i := from; i <= to; i += step {
+			b |= 1 << uint(i)
+		}
+	}
+	return b, star, nil
+}
+
+func value(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"@every 10ms",
+		"@every soon",
+		"TZ=Nowhere/City * * * * *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	utc := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{name: "every minute", spec: "* * * * *", from: utc("2024-01-01 10:00"), want: utc("2024-01-01 10:01")},
+		{name: "seconds truncated", spec: "* * * * *", from: utc("2024-01-01 10:00").Add(30 * time.Second), want: utc("2024-01-01 10:01")},
+		{name: "list", spec: "0,30 * * * *", from: utc("2024-01-01 10:05"), want: utc("2024-01-01 10:30")},
+		{name: "range", spec: "0 9-17 * * *", from: utc("2024-01-01 18:00"), want: utc("2024-01-02 09:00")},
+		{name: "step", spec: "*/15 * * * *", from: utc("2024-01-01 10:16"), want: utc("2024-01-01 10:30")},
+		{name: "step from value", spec: "5/15 * * * *", from: utc("2024-01-01 10:21"), want: utc("2024-01-01 10:35")},
+		{name: "step from value wraps", spec: "5/15 * * * *", from: utc("2024-01-01 10:50"), want: utc("2024-01-01 11:05")},
+		{name: "month name", spec: "0 0 1 mar *", from: utc("2024-01-15 00:00"), want: utc("2024-03-01 00:00")},
+		{name: "weekday name", spec: "0 0 * * fri", from: utc("2024-01-01 00:00"), want: utc("2024-01-05 00:00")},
+		{name: "7 is sunday", spec: "0 0 * * 7", from: utc("2024-01-01 00:00"), want: utc("2024-01-07 00:00")},
+		{name: "0 is sunday", spec: "0 0 * * 0", from: utc("2024-01-01 00:00"), want: utc("2024-01-07 00:00")},
+		{name: "question mark", spec: "0 0 ? * mon", from: utc("2024-01-01 00:00"), want: utc("2024-01-08 00:00")},
+		// both day fields restricted: either one matches
+		{name: "dom or dow, dow first", spec: "0 0 13 * fri", from: utc("2024-01-01 00:00"), want: utc("2024-01-05 00:00")},
+		{name: "dom or dow, dom first", spec: "0 0 2 * fri", from: utc("2024-01-01 00:00"), want: utc("2024-01-02 00:00")},
+		{name: "dom only", spec: "0 0 13 * *", from: utc("2024-01-01 00:00"), want: utc("2024-01-13 00:00")},
+		{name: "leap day", spec: "0 0 29 2 *", from: utc("2024-03-01 00:00"), want: utc("2028-02-29 00:00")},
+		{name: "never", spec: "0 0 30 2 *", from: utc("2024-01-01 00:00"), want: time.Time{}},
+		{name: "descriptor", spec: "@daily", from: utc("2024-01-01 10:00"), want: utc("2024-01-02 00:00")},
+		{name: "every", spec: "@every 1h", from: utc("2024-01-01 10:20"), want: utc("2024-01-01 11:00")},
+		{name: "time zone", spec: "TZ=America/New_York 0 9 * * *", from: utc("2024-01-01 15:00"), want: utc("2024-01-02 14:00")},
+		// 02:30 does not exist on the day clocks spring forward
+		{name: "dst gap", spec: "TZ=America/New_York 30 2 * * *", from: time.Date(2024, 3, 9, 3, 0, 0, 0, ny), want: time.Date(2024, 3, 11, 2, 30, 0, 0, ny)},
+		{name: "dst overlap", spec: "TZ=America/New_York 30 1 * * *", from: time.Date(2024, 11, 2, 2, 0, 0, 0, ny), want: time.Date(2024, 11, 3, 1, 30, 0, 0, ny)},
+		{name: "dst overlap repeats", spec: "TZ=America/New_York 30 1 * * *", from: time.Date(2024, 11, 3, 1, 30, 0, 0, ny), want: utc("2024-11-03 06:30")},
+		{name: "dst hourly", spec: "TZ=America/New_York 0 * * * *", from: time.Date(2024, 3, 10, 1, 30, 0, 0, ny), want: time.Date(2024, 3, 10, 3, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q): %v", tt.spec, err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultMaxAttempts  = 5
+	defaultMinBackoff   = time.Second
+	defaultMaxBackoff   = time.Minute
+	defaultClaimAfter   = 5 * time.Minute
+	defaultConcurrency  = 10
+	defaultDrainTimeout = 30 * time.Second
+)
+
+// Job fields of a stream entry.
+const (
+	typeField     = "type"
+	dataField     = "data"
+	enqueuedField = "enqueued"
+)
+
+// trimScript drops the entries of stream KEYS[1] that every consumer group
+// has read and acknowledged, and returns how many it dropped. Each group
+// needs what follows its oldest pending entry, or its last delivered one
+// when nothing is pending. Streams without groups are left alone.
+var trimScript = redis.NewScript(`
+local function older(a, b)
+	local ams, aseq = string.match(a, "(%d+)-(%d+)")
+	local bms, bseq = string.match(b, "(%d+)-(%d+)")
+	ams, bms = tonumber(ams), tonumber(bms)
+	return ams < bms or (ams == bms and tonumber(aseq) < tonumber(bseq))
+end
+
+local groups = redis.call("XINFO", "GROUPS", KEYS[1])
+local minid
+for _, g in ipairs(groups) do
+	local info = {}
+	for i = 1, #g, 2 do
+		info[g[i]] = g[i + 1]
+	end
+	local id = info["last-delivered-id"]
+	if tonumber(info["pending"]) > 0 then
+		id = redis.call("XPENDING", KEYS[1], info["name"])[2]
+	end
+	if not minid or older(id, minid) then
+		minid = id
+	end
+end
+if not minid then
+	return 0
+end
+return redis.call("XTRIM", KEYS[1], "MINID", minid)
+`)
+
+// ErrPermanent marks failures retrying cannot fix; handlers wrap it to send
+// a job straight to the dead-letter stream.
+var ErrPermanent = errors.New("queue: permanent failure")
+
+type (
+	// Config describes one queue. In cluster mode Stream needs a hash tag,
+	// e.g. "{jobs}", so that the keys named after it share its slot.
+	Config struct {
+		Stream string `yaml:"stream"`
+		// Group is the consumer group; every group receives every job.
+		// Jobs are trimmed once all groups acknowledged them, so a group
+		// added later starts with the jobs still in the stream.
+		Group string `yaml:"group"`
+		// DeadLetter receives jobs that failed MaxAttempts times,
+		// Stream+":dead" by default.
+		DeadLetter  string `yaml:"dead_letter"`
+		MaxAttempts int    `yaml:"max_attempts"`
+		// MinBackoff is the wait before the first retry; it doubles with
+		// every further attempt up to MaxBackoff.
+		MinBackoff time.Duration `yaml:"min_backoff"`
+		MaxBackoff time.Duration `yaml:"max_backoff"`
+		// ClaimAfter is how long a job may go unacknowledged before its
+		// worker is presumed dead and another one claims it. Handlers are
+		// cancelled once it has passed.
+		ClaimAfter  time.Duration `yaml:"claim_after"`
+		Concurrency int           `yaml:"concurrency"`
+		// DrainTimeout bounds how long a stopping worker waits for running
+		// jobs before cancelling them.
+		DrainTimeout time.Duration `yaml:"drain_timeout"`
+	}
+
+	// Job is a job as seen by its handler. Attempt starts at 1.
+	Job[T any] struct {
+		ID       string
+		Type     string
+		Attempt  int
+		Enqueued time.Time
+		Data     T
+	}
+
+	// Queue is a durable job queue on a redis stream. Jobs stay pending in
+	// the consumer group until a worker acknowledges them, so none are lost
+	// when a worker dies.
+	Queue struct {
+		db  redis.UniversalClient
+		cnf Config
+	}
+)
+
+func New(db redis.UniversalClient, cnf Config) (*Queue, error) {
+	if cnf.Stream == "" || cnf.Group == "" {
+		return nil, errors.New("queue: stream and group are required")
+	}
+	if cnf.DeadLetter == "" {
+		cnf.DeadLetter = cnf.Stream + ":dead"
+	}
+	if cnf.MaxAttempts <= 0 {
+		cnf.MaxAttempts = defaultMaxAttempts
+	}
+	if cnf.MinBackoff <= 0 {
+		cnf.MinBackoff = defaultMinBackoff
+	}
+	if cnf.MaxBackoff <= 0 {
+		cnf.MaxBackoff = defaultMaxBackoff
+	}
+	if cnf.ClaimAfter <= 0 {
+		cnf.ClaimAfter = defaultClaimAfter
+	}
+	if cnf.Concurrency <= 0 {
+		cnf.Concurrency = defaultConcurrency
+	}
+	if cnf.DrainTimeout <= 0 {
+		cnf.DrainTimeout = defaultDrainTimeout
+	}
+	// jobs idle for ClaimAfter are claimed whatever their backoff
+	if cnf.MaxBackoff > cnf.ClaimAfter {
+		return nil, errors.New("queue: max_backoff must not exceed claim_after")
+	}
+
+	return &Queue{db: db, cnf: cnf}, nil
+}
+
+// Enqueue adds a job of type typ with v as its JSON encoded data and
+// returns its id.
+func (q *Queue) Enqueue(ctx context.Context, typ string, v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return q.db.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.cnf.Stream,
+		Values: []interface{}{typeField, typ, dataField, data, enqueuedField, time.Now().UnixMilli()},
+	}).Result()
+}
+
+// Redrive moves dead-lettered jobs back onto the queue, where they start
+// over at attempt 1. It returns how many of ids were found.
+func (q *Queue) Redrive(ctx context.Context, ids ...string) (int, error) {
+	n := 0
+	for _, id := range ids {
+		msgs, err := q.db.XRangeN(ctx, q.cnf.DeadLetter, id, id, 1).Result()
+		if err != nil {
+			return n, err
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+
+		v := msgs[0].Values
+		_, err = q.db.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.XAdd(ctx, &redis.XAddArgs{
+				Stream: q.cnf.Stream,
+				Values: []interface{}{typeField, v[typeField], dataField, v[dataField], enqueuedField, v[enqueuedField]},
+			})
+			p.XDel(ctx, q.cnf.DeadLetter, id)
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Trim drops the jobs every group has acknowledged and returns how many it
+// dropped. Workers call it every minute.
+func (q *Queue) Trim(ctx context.Context) (int64, error) {
+	return trimScript.Run(ctx, q.db, []string{q.cnf.Stream}).Int64()
+}
+
+// createGroup creates the stream and group unless they exist. The group
+// starts at the beginning, so jobs enqueued before any worker ran are kept.
+func (q *Queue) createGroup(ctx context.Context) error {
+	err := q.db.XGroupCreateMkStream(ctx, q.cnf.Stream, q.cnf.Group, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// backoff is the wait before the attempt after attempt.
+func (q *Queue) backoff(attempt int) time.Duration {
+	d := q.cnf.MinBackoff
+	for i := 1; i < attempt && d < q.cnf.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, q.cnf.MaxBackoff)
+}
+
+func field(msg redis.XMessage, name string) string {
+	s, _ := msg.Values[name].(string)
+	return s
+}
@@ -0,0 +1,315 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"my-go-app/redis/cahce/storage"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatch        = 100
+)
+
+// promoteScript moves up to ARGV[1] delayed jobs that are due from the
+// KEYS[1] sorted set, with their payloads in the KEYS[2] hash, onto the
+// KEYS[3] stream. It returns how many it moved.
+var promoteScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", now, "LIMIT", 0, tonumber(ARGV[1]))
+for _, id in ipairs(ids) do
+	local payload = redis.call("HGET", KEYS[2], id)
+	if payload then
+		local job = cjson.decode(payload)
+		redis.call("XADD", KEYS[3], "*", "type", job.type, "data", job.data, "enqueued", now)
+	end
+	redis.call("ZREM", KEYS[1], id)
+	redis.call("HDEL", KEYS[2], id)
+end
+return #ids
+`)
+
+// fireScript enqueues one run of the recurring job ARGV[1], defined in the
+// KEYS[2] hash, and moves its next run in the KEYS[1] sorted set to
+// ARGV[3]. It only does so while the next run is still ARGV[2], so of the
+// instances racing for a run exactly one enqueues it. It returns 1 when the
+// job was enqueued.
+var fireScript = redis.NewScript(`
+local due = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not due or tonumber(due) ~= tonumber(ARGV[2]) then
+	return 0
+end
+
+local payload = redis.call("HGET", KEYS[2], ARGV[1])
+if not payload then
+	redis.call("ZREM", KEYS[1], ARGV[1])
+	return 0
+end
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local job = cjson.decode(payload)
+redis.call("XADD", KEYS[3], "*", "type", job.type, "data", job.data, "enqueued", now)
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+type (
+	SchedulerConfig struct {
+		// PollInterval is how often due jobs are looked for, 1s by default.
+		PollInterval time.Duration `yaml:"poll_interval"`
+		// Batch caps the jobs moved per script call, 100 by default.
+		Batch int `yaml:"batch"`
+	}
+
+	// Scheduler enqueues delayed and recurring jobs once they are due.
+	// Both live in redis, so they survive restarts, and any number of
+	// instances may run schedulers for the same queue.
+	Scheduler struct {
+		q   *Queue
+		cnf SchedulerConfig
+
+		// delayed holds the due time of every delayed job, payloads their
+		// type and data by id.
+		delayed, payloads string
+		// recurring holds the definition of every recurring job, next its
+		// next run by name.
+		recurring, next string
+	}
+
+	// payload is how scheduled jobs are stored until they are enqueued.
+	payload struct {
+		Spec string `json:"spec,omitempty"`
+		Type string `json:"type"`
+		Data string `json:"data"`
+	}
+)
+
+func NewScheduler(q *Queue, cnf SchedulerConfig) *Scheduler {
+	if cnf.PollInterval <= 0 {
+		cnf.PollInterval = defaultPollInterval
+	}
+	if cnf.Batch <= 0 {
+		cnf.Batch = defaultBatch
+	}
+
+	stream := q.cnf.Stream
+	return &Scheduler{
+		q:         q,
+		cnf:       cnf,
+		delayed:   stream + ":delayed",
+		payloads:  stream + ":delayed:jobs",
+		recurring: stream + ":recurring",
+		next:      stream + ":recurring:next",
+	}
+}
+
+// EnqueueAt enqueues a job once at has come and returns an id for Cancel.
+func (s *Scheduler) EnqueueAt(ctx context.Context, at time.Time, typ string, v any) (string, error) {
+	p, err := encodePayload("", typ, v)
+	if err != nil {
+		return "", err
+	}
+	id, err := storage.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.q.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.payloads, id, p)
+		pipe.ZAdd(ctx, s.delayed, &redis.Z{Score: float64(at.UnixMilli()), Member: id})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// EnqueueIn enqueues a job once d has passed.
+func (s *Scheduler) EnqueueIn(ctx context.Context, d time.Duration, typ string, v any) (string, error) {
+	return s.EnqueueAt(ctx, time.Now().Add(d), typ, v)
+}
+
+// Cancel drops a delayed job and reports whether it was still waiting.
+func (s *Scheduler) Cancel(ctx context.Context, id string) (bool, error) {
+	var removed *redis.IntCmd
+	_, err := s.q.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, s.delayed, id)
+		pipe.HDel(ctx, s.payloads, id)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed.Val() == 1, nil
+}
+
+// Schedule defines the recurring job name, enqueued with type typ and data
+// v whenever spec (see ParseSchedule) says so. Defining a job again, e.g.
+// on every start, keeps its next run unless spec changed. Runs missed while
+// no scheduler was running are made up for by a single run.
+func (s *Scheduler) Schedule(ctx context.Context, name, spec, typ string, v any) error {
+	sched, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	p, err := encodePayload(spec, typ, v)
+	if err != nil {
+		return err
+	}
+
+	now, err := s.q.db.Time(ctx).Result()
+	if err != nil {
+		return err
+	}
+	next := sched.Next(now)
+	if next.IsZero() {
+		return fmt.Errorf("queue: schedule %q never runs", spec)
+	}
+
+	var prev payload
+	old, err := s.q.db.HGet(ctx, s.recurring, name).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal([]byte(old), &prev); err != nil {
+			log.Printf("queue: %s: recurring job %s: %v", s.q.cnf.Stream, name, err)
+		}
+	}
+
+	z := &redis.Z{Score: float64(next.UnixMilli()), Member: name}
+	_, err = s.q.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.recurring, name, p)
+		if prev.Spec == spec {
+			pipe.ZAddNX(ctx, s.next, z)
+		} else {
+			pipe.ZAdd(ctx, s.next, z)
+		}
+		return nil
+	})
+	return err
+}
+
+// Unschedule removes the recurring job name.
+func (s *Scheduler) Unschedule(ctx context.Context, name string) error {
+	_, err := s.q.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, s.recurring, name)
+		pipe.ZRem(ctx, s.next, name)
+		return nil
+	})
+	return err
+}
+
+// Run enqueues due jobs every PollInterval until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.cnf.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.promote(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("queue: %s: enqueueing delayed jobs: %v", s.q.cnf.Stream, err)
+		}
+		if err := s.fire(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("queue: %s: enqueueing recurring jobs: %v", s.q.cnf.Stream, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// promote moves due delayed jobs onto the queue, batch by batch.
+func (s *Scheduler) promote(ctx context.Context) error {
+	keys := []string{s.delayed, s.payloads, s.q.cnf.Stream}
+	for {
+		n, err := promoteScript.Run(ctx, s.q.db, keys, s.cnf.Batch).Int()
+		if err != nil || n < s.cnf.Batch {
+			return err
+		}
+	}
+}
+
+// fire enqueues the recurring jobs that are due. The next run is computed
+// from now, not from the run that was due, so missed runs collapse into
+// one.
+func (s *Scheduler) fire(ctx context.Context) error {
+	now, err := s.q.db.Time(ctx).Result()
+	if err != nil {
+		return err
+	}
+	due, err := s.q.db.ZRangeByScoreWithScores(ctx, s.next, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(s.cnf.Batch),
+	}).Result()
+	if err != nil || len(due) == 0 {
+		return err
+	}
+
+	names := make([]string, len(due))
+	for i, z := range due {
+		names[i], _ = z.Member.(string)
+	}
+	defs, err := s.q.db.HMGet(ctx, s.recurring, names...).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{s.next, s.recurring, s.q.cnf.Stream}
+	for i, name := range names {
+		raw, _ := defs[i].(string)
+		var p payload
+		if err := json.Unmarshal([]byte(raw), &p); err != nil {
+			log.Printf("queue: %s: recurring job %s: %v", s.q.cnf.Stream, name, err)
+			continue
+		}
+		sched, err := ParseSchedule(p.Spec)
+		if err != nil {
+			log.Printf("queue: %s: recurring job %s: %v", s.q.cnf.Stream, name, err)
+			continue
+		}
+
+		next := sched.Next(now)
+		if next.IsZero() {
+			log.Printf("queue: %s: recurring job %s never runs again, removing it", s.q.cnf.Stream, name)
+			if err := s.Unschedule(ctx, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		args := []interface{}{name, int64(due[i].Score), next.UnixMilli()}
+		if _, err := fireScript.Run(ctx, s.q.db, keys, args...).Int(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodePayload(spec, typ string, v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(payload{Spec: spec, Type: typ, Data: string(data)})
+	if err != nil {
+		return "", err
+	}
+	return string(p), nil
+}
@@ -0,0 +1,420 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"my-go-app/redis/cahce/metrics"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// retryConsumer holds failed jobs in the group until their backoff has
+	// passed and a worker claims them again.
+	retryConsumer = "_retry"
+
+	readBlock    = 2 * time.Second
+	pollInterval = time.Second
+	claimBatch   = 100
+	trimInterval = time.Minute
+	maxReadDelay = 5 * time.Second
+)
+
+type (
+	// Worker runs jobs of one queue as a consumer of its group. Consumer
+	// names must be unique within the group, e.g. the host name.
+	Worker struct {
+		q        *Queue
+		consumer string
+		handlers map[string]handler
+		// cursor is where the next XAUTOCLAIM scan starts.
+		cursor string
+	}
+
+	handler func(ctx context.Context, msg redis.XMessage, attempt int) error
+
+	delivery struct {
+		msg     redis.XMessage
+		attempt int
+	}
+)
+
+func NewWorker(q *Queue, consumer string) *Worker {
+	return &Worker{q: q, consumer: consumer, handlers: make(map[string]handler), cursor: "0-0"}
+}
+
+// Handle registers the handler for jobs of type typ. It must be called
+// before Run. A nil error acknowledges the job; any other error retries it
+// with backoff, unless it wraps ErrPermanent or the job is out of attempts,
+// in which case the job is dead-lettered. Jobs whose data does not decode
+// into T are dead-lettered as well.
+func Handle[T any](w *Worker, typ string, fn func(ctx context.Context, job Job[T]) error) {
+	w.handlers[typ] = func(ctx context.Context, msg redis.XMessage, attempt int) error {
+		job := Job[T]{ID: msg.ID, Type: typ, Attempt: attempt}
+		if ms, err := strconv.ParseInt(field(msg, enqueuedField), 10, 64); err == nil {
+			job.Enqueued = time.UnixMilli(ms)
+		}
+		if err := json.Unmarshal([]byte(field(msg, dataField)), &job.Data); err != nil {
+			return fmt.Errorf("%w: decoding data: %v", ErrPermanent, err)
+		}
+		return fn(ctx, job)
+	}
+}
+
+// Run reads and runs jobs until ctx is done, then stops reading and waits
+// up to DrainTimeout for running jobs before cancelling them. Jobs it did
+// not finish stay pending and are picked up by other workers.
+func (w *Worker) Run(ctx context.Context) error {
+	cnf := w.q.cnf
+	if err := w.q.createGroup(ctx); err != nil {
+		return err
+	}
+
+	// running jobs and their bookkeeping outlive ctx while draining
+	bg := context.WithoutCancel(ctx)
+	jobCtx, cancel := context.WithCancel(bg)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		select {
+		case <-time.After(cnf.DrainTimeout):
+			log.Printf("queue: %s: drain timed out, cancelling running jobs", cnf.Stream)
+			cancel()
+		case <-done:
+		}
+	}()
+
+	jobs := make(chan delivery)
+	var running sync.WaitGroup
+	for i := 0; i < cnf.Concurrency; i++ {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			for d := range jobs {
+				w.process(jobCtx, bg, d)
+			}
+		}()
+	}
+
+	var feeding sync.WaitGroup
+	feeding.Add(2)
+	go func() {
+		defer feeding.Done()
+		w.read(ctx, bg, jobs)
+	}()
+	go func() {
+		defer feeding.Done()
+		w.reclaim(ctx, bg, jobs)
+	}()
+
+	feeding.Wait()
+	close(jobs)
+	running.Wait()
+	close(done)
+	return ctx.Err()
+}
+
+// read hands out new jobs until ctx is done. Reads use bg since cancelling
+// a blocked read would drop the jobs redis delivers with it; they would
+// only be recovered after ClaimAfter.
+func (w *Worker) read(ctx, bg context.Context, jobs chan<- delivery) {
+	cnf := w.q.cnf
+	backoff := time.Duration(0)
+	for ctx.Err() == nil {
+		streams, err := w.q.db.XReadGroup(bg, &redis.XReadGroupArgs{
+			Group:    cnf.Group,
+			Consumer: w.consumer,
+			Streams:  []string{cnf.Stream, ">"},
+			Count:    int64(cnf.Concurrency),
+			Block:    readBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			backoff = 0
+			continue
+		}
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				// the stream was deleted
+				err = w.q.createGroup(bg)
+			}
+			backoff = min(max(2*backoff, 100*time.Millisecond), maxReadDelay)
+			log.Printf("queue: %s: read: %v, retrying in %s", cnf.Stream, err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		backoff = 0
+
+		for _, s := range streams {
+			for _, msg := range s.Messages {
+				jobs <- delivery{msg: msg, attempt: 1}
+			}
+		}
+	}
+}
+
+// reclaim hands out failed jobs whose backoff has passed and jobs of dead
+// workers until ctx is done. It trims the stream along the way.
+func (w *Worker) reclaim(ctx, bg context.Context, jobs chan<- delivery) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	var trimmed time.Time
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := w.retries(bg, jobs); err != nil {
+			log.Printf("queue: %s: claiming retries: %v", w.q.cnf.Stream, err)
+		}
+		if err := w.recover(bg, jobs); err != nil {
+			log.Printf("queue: %s: claiming jobs of dead workers: %v", w.q.cnf.Stream, err)
+		}
+		if time.Since(trimmed) >= trimInterval {
+			trimmed = time.Now()
+			if _, err := w.q.Trim(bg); err != nil {
+				log.Printf("queue: %s: trimming: %v", w.q.cnf.Stream, err)
+			}
+		}
+	}
+}
+
+// retries claims failed jobs parked with retryConsumer once they waited
+// out their backoff.
+func (w *Worker) retries(ctx context.Context, jobs chan<- delivery) error {
+	cnf := w.q.cnf
+	pending, err := w.q.db.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   cnf.Stream,
+		Group:    cnf.Group,
+		Idle:     cnf.MinBackoff,
+		Start:    "-",
+		End:      "+",
+		Count:    claimBatch,
+		Consumer: retryConsumer,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		wait := w.q.backoff(int(p.RetryCount))
+		if p.Idle < wait {
+			continue
+		}
+		// the min idle time lets only one of the workers racing for the
+		// job claim it, since claiming resets it
+		msgs, err := w.q.db.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   cnf.Stream,
+			Group:    cnf.Group,
+			Consumer: w.consumer,
+			MinIdle:  wait,
+			Messages: []string{p.ID},
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			jobs <- delivery{msg: msg, attempt: int(p.RetryCount) + 1}
+		}
+	}
+	return nil
+}
+
+// recover claims jobs that went unacknowledged for ClaimAfter, i.e. whose
+// worker died or lost its connection.
+func (w *Worker) recover(ctx context.Context, jobs chan<- delivery) error {
+	cnf := w.q.cnf
+	// go-redis v8 cannot parse the three element XAUTOCLAIM reply of
+	// redis 7, so the reply is read by hand
+	res, err := w.q.db.Do(ctx, "XAUTOCLAIM", cnf.Stream, cnf.Group, w.consumer,
+		cnf.ClaimAfter.Milliseconds(), w.cursor, "COUNT", claimBatch).Slice()
+	if err != nil {
+		return err
+	}
+	cursor, msgs, err := parseAutoClaim(res)
+	if err != nil {
+		return err
+	}
+	w.cursor = cursor
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	// the delivery counts, which the claim incremented, come from XPENDING
+	counts := make([]*redis.XPendingExtCmd, len(msgs))
+	_, err = w.q.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, msg := range msgs {
+			counts[i] = p.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: cnf.Stream,
+				Group:  cnf.Group,
+				Start:  msg.ID,
+				End:    msg.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, msg := range msgs {
+		attempt := cnf.MaxAttempts
+		if p := counts[i].Val(); len(p) == 1 {
+			attempt = int(p[0].RetryCount)
+		}
+		log.Printf("queue: %s: claimed job %s of a dead worker, attempt %d", cnf.Stream, msg.ID, attempt)
+		jobs <- delivery{msg: msg, attempt: attempt}
+	}
+	return nil
+}
+
+// process runs one job and settles it: acknowledged, parked for a retry or
+// dead-lettered. Settling uses bg so it happens even while shutting down.
+func (w *Worker) process(ctx, bg context.Context, d delivery) {
+	cnf := w.q.cnf
+	typ := field(d.msg, typeField)
+
+	if ctx.Err() != nil {
+		// drain timed out: let another worker run it
+		w.retry(bg, d, typ, ctx.Err())
+		return
+	}
+
+	var (
+		err     error
+		elapsed time.Duration
+	)
+	handle, ok := w.handlers[typ]
+	switch {
+	case !ok:
+		err = fmt.Errorf("%w: no handler for type %q", ErrPermanent, typ)
+	case d.attempt > cnf.MaxAttempts:
+		// the worker running the last attempt died, maybe because of it
+		err = fmt.Errorf("%w: attempt %d of %d", ErrPermanent, d.attempt, cnf.MaxAttempts)
+	default:
+		start := time.Now()
+		jobCtx, cancel := context.WithTimeout(ctx, cnf.ClaimAfter)
+		err = handle(jobCtx, d.msg, d.attempt)
+		cancel()
+		elapsed = time.Since(start)
+	}
+
+	switch {
+	case err == nil:
+		if err := w.ack(bg, d.msg.ID); err != nil {
+			log.Printf("queue: %s: ack %s: %v", cnf.Stream, d.msg.ID, err)
+		}
+		metrics.ObserveJob(cnf.Stream, typ, metrics.JobDone, elapsed)
+	case errors.Is(err, ErrPermanent) || d.attempt >= cnf.MaxAttempts:
+		w.deadLetter(bg, d, typ, err)
+		metrics.ObserveJob(cnf.Stream, typ, metrics.JobDead, elapsed)
+	default:
+		w.retry(bg, d, typ, err)
+		metrics.ObserveJob(cnf.Stream, typ, metrics.JobRetried, elapsed)
+	}
+}
+
+// ack acknowledges the job. It stays in the stream for the other groups
+// until Trim drops it.
+func (w *Worker) ack(ctx context.Context, id string) error {
+	return w.q.db.XAck(ctx, w.q.cnf.Stream, w.q.cnf.Group, id).Err()
+}
+
+// retry parks the job with retryConsumer. JUSTID keeps the delivery count,
+// which counts attempts, and the claim restarts the idle time the backoff
+// is measured by.
+func (w *Worker) retry(ctx context.Context, d delivery, typ string, cause error) {
+	cnf := w.q.cnf
+	log.Printf("queue: %s: job %s (%s) failed on attempt %d of %d, retrying in %s: %v",
+		cnf.Stream, d.msg.ID, typ, d.attempt, cnf.MaxAttempts, w.q.backoff(d.attempt), cause)
+
+	err := w.q.db.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   cnf.Stream,
+		Group:    cnf.Group,
+		Consumer: retryConsumer,
+		Messages: []string{d.msg.ID},
+	}).Err()
+	if err != nil {
+		// still pending, so it is recovered after ClaimAfter
+		log.Printf("queue: %s: parking %s for retry: %v", cnf.Stream, d.msg.ID, err)
+	}
+}
+
+// deadLetter moves the job to the dead-letter stream along with why and
+// when it failed.
+func (w *Worker) deadLetter(ctx context.Context, d delivery, typ string, cause error) {
+	cnf := w.q.cnf
+	log.Printf("queue: %s: job %s (%s) dead-lettered after %d attempts: %v",
+		cnf.Stream, d.msg.ID, typ, d.attempt, cause)
+
+	values := make([]interface{}, 0, 2*len(d.msg.Values)+8)
+	for k, v := range d.msg.Values {
+		values = append(values, k, v)
+	}
+	values = append(values,
+		"id", d.msg.ID,
+		"error", cause.Error(),
+		"attempts", d.attempt,
+		"failed", time.Now().UnixMilli(),
+	)
+
+	_, err := w.q.db.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.XAdd(ctx, &redis.XAddArgs{Stream: cnf.DeadLetter, Values: values})
+		p.XAck(ctx, cnf.Stream, cnf.Group, d.msg.ID)
+		return nil
+	})
+	if err != nil {
+		log.Printf("queue: %s: dead-lettering %s: %v", cnf.Stream, d.msg.ID, err)
+	}
+}
+
+// parseAutoClaim reads the cursor and claimed entries of an XAUTOCLAIM
+// reply. Entries deleted from the stream meanwhile are skipped.
+func parseAutoClaim(res []interface{}) (string, []redis.XMessage, error) {
+	if len(res) < 2 {
+		return "", nil, fmt.Errorf("queue: unexpected XAUTOCLAIM reply %v", res)
+	}
+	cursor, _ := res[0].(string)
+	entries, _ := res[1].([]interface{})
+
+	msgs := make([]redis.XMessage, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) != 2 {
+			continue
+		}
+		id, _ := entry[0].(string)
+		fields, ok := entry[1].([]interface{})
+		if !ok {
+			continue
+		}
+
+		values := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if k, ok := fields[i].(string); ok {
+				values[k] = fields[i+1]
+			}
+		}
+		msgs = append(msgs, redis.XMessage{ID: id, Values: values})
+	}
+	return cursor, msgs, nil
+}
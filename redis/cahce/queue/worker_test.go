@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestParseAutoClaim(t *testing.T) {
+	job := []interface{}{"1-0", []interface{}{"type", "mail", "data", "{}"}}
+
+	tests := []struct {
+		name       string
+		res        []interface{}
+		wantCursor string
+		want       []redis.XMessage
+		wantErr    bool
+	}{
+		{name: "short reply", res: []interface{}{"0-0"}, wantErr: true},
+		{name: "nothing claimed", res: []interface{}{"0-0", []interface{}{}}, wantCursor: "0-0", want: []redis.XMessage{}},
+		{
+			name:       "redis 6.2",
+			res:        []interface{}{"2-0", []interface{}{job}},
+			wantCursor: "2-0",
+			want:       []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"type": "mail", "data": "{}"}}},
+		},
+		{
+			name:       "redis 7 with deleted ids",
+			res:        []interface{}{"0-0", []interface{}{job}, []interface{}{"0-5"}},
+			wantCursor: "0-0",
+			want:       []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"type": "mail", "data": "{}"}}},
+		},
+		{
+			name:       "deleted entries skipped",
+			res:        []interface{}{"0-0", []interface{}{nil, []interface{}{"3-0", nil}, job}},
+			wantCursor: "0-0",
+			want:       []redis.XMessage{{ID: "1-0", Values: map[string]interface{}{"type": "mail", "data": "{}"}}},
+		},
+		{
+			name:       "odd field count",
+			res:        []interface{}{"0-0", []interface{}{[]interface{}{"4-0", []interface{}{"type", "mail", "data"}}}},
+			wantCursor: "0-0",
+			want:       []redis.XMessage{{ID: "4-0", Values: map[string]interface{}{"type": "mail"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, msgs, err := parseAutoClaim(tt.res)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAutoClaim error = %v, want error %v", err, tt.wantErr)
+			}
+			if cursor != tt.wantCursor {
+				t.Errorf("cursor = %q, want %q", cursor, tt.wantCursor)
+			}
+			if !reflect.DeepEqual(msgs, tt.want) {
+				t.Errorf("messages = %v, want %v", msgs, tt.want)
+			}
+		})
+	}
+}
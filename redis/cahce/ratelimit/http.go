@@ -0,0 +1,272 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FailOpen lets requests through while limits cannot be checked.
+	FailOpen FailurePolicy = iota
+	// FailClosed answers 503 while limits cannot be checked.
+	FailClosed
+)
+
+// anonymous is the key shared by requests KeyFunc finds no key for.
+const anonymous = "anonymous"
+
+type (
+	FailurePolicy int
+
+	// KeyFunc names the client a request is counted against, or returns
+	// "" when it cannot tell.
+	KeyFunc func(r *http.Request) string
+
+	MiddlewareConfig struct {
+		// Key picks the client, ByIP(nil) by default. Requests it returns
+		// no key for share one "anonymous" limit.
+		Key     KeyFunc
+		Failure FailurePolicy
+	}
+
+	ConcurrencyConfig struct {
+		// Name is the semaphore name all requests share, unless Key splits
+		// them by client.
+		Name string
+		Key  KeyFunc
+		// Wait is how long a request may queue for a free slot before it
+		// is answered 503. Zero does not queue.
+		Wait    time.Duration
+		Failure FailurePolicy
+	}
+)
+
+// Middleware answers 429 Too Many Requests once a client exceeds its limit
+// and reports the limit in RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, plus Retry-After when limited.
+func Middleware(l *Limiter, cnf MiddlewareConfig) func(http.Handler) http.Handler {
+	if cnf.Key == nil {
+		cnf.Key = ByIP(nil)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := cnf.Key(r)
+			if key == "" {
+				key = anonymous
+			}
+
+			res, err := l.Allow(r.Context(), key)
+			if err != nil {
+				if cnf.Failure == FailOpen {
+					log.Printf("ratelimit: %s: %v, letting request through", key, err)
+					next.ServeHTTP(w, r)
+					return
+				}
+				log.Printf("ratelimit: %s: %v, rejecting request", key, err)
+				http.Error(w, "rate limit unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(max(res.Remaining, 0)))
+			h.Set("RateLimit-Reset", seconds(res.ResetAfter))
+
+			if !res.Allowed {
+				if res.RetryAfter >= 0 {
+					h.Set("Retry-After", seconds(res.RetryAfter))
+				}
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ConcurrencyMiddleware answers 503 Service Unavailable while the
+// semaphore's leases are all held, i.e. too many requests are in flight
+// across all instances.
+func ConcurrencyMiddleware(s *Semaphore, cnf ConcurrencyConfig) func(http.Handler) http.Handler {
+	if cnf.Name == "" {
+		cnf.Name = "http"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := cnf.Name
+			if cnf.Key != nil {
+				key := cnf.Key(r)
+				if key == "" {
+					key = anonymous
+				}
+				name += ":" + key
+			}
+
+			lease, err := acquire(r.Context(), s, name, cnf.Wait)
+			switch {
+			case errors.Is(err, ErrSaturated):
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many requests in flight", http.StatusServiceUnavailable)
+				return
+			case err != nil && cnf.Failure == FailOpen:
+				log.Printf("ratelimit: %s: %v, letting request through", name, err)
+				next.ServeHTTP(w, r)
+				return
+			case err != nil:
+				log.Printf("ratelimit: %s: %v, rejecting request", name, err)
+				http.Error(w, "concurrency limit unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			defer func() {
+				if err := lease.Release(context.WithoutCancel(r.Context())); err != nil {
+					log.Printf("ratelimit: %s: release: %v", name, err)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// acquire takes a lease, waiting up to wait for one. Running out of wait
+// counts as saturated.
+func acquire(ctx context.Context, s *Semaphore, name string, wait time.Duration) (*Lease, error) {
+	if wait <= 0 {
+		return s.TryAcquire(ctx, name)
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	lease, err := s.Acquire(wctx, name)
+	if err != nil && wctx.Err() != nil && ctx.Err() == nil {
+		return nil, ErrSaturated
+	}
+	return lease, err
+}
+
+// ByIP keys requests by client IP. X-Forwarded-For is only believed when
+// the request comes from one of trusted, e.g. a load balancer; the client
+// is then the rightmost address in it that is not trusted as well.
+func ByIP(trusted []netip.Prefix) KeyFunc {
+	isTrusted := func(ip netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		ip, err := remoteIP(r.RemoteAddr)
+		if err != nil {
+			return ""
+		}
+		if !isTrusted(ip) {
+			return "ip:" + ip.String()
+		}
+
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			ip = hop.Unmap()
+			if !isTrusted(ip) {
+				break
+			}
+		}
+		return "ip:" + ip.String()
+	}
+}
+
+// ByHeader keys requests by an API key header such as X-API-Key. Keys are
+// hashed so they do not show up in redis. Only use it behind a middleware
+// that rejects unknown keys: otherwise clients escape their limit by
+// sending a new key with every request.
+func ByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		v := r.Header.Get(name)
+		if v == "" {
+			return ""
+		}
+		sum := sha256.Sum256([]byte(v))
+		return "key:" + hex.EncodeToString(sum[:16])
+	}
+}
+
+// ByUser keys requests by the authenticated user user returns, usually
+// taken from the request context by an auth middleware.
+func ByUser(user func(r *http.Request) string) KeyFunc {
+	return func(r *http.Request) string {
+		if id := user(r); id != "" {
+			return "user:" + id
+		}
+		return ""
+	}
+}
+
+// First uses the first of keys that finds a key, e.g. First(ByUser(f),
+// ByIP(nil)) with f returning the user an auth middleware verified.
+func First(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		for _, key := range keys {
+			if k := key(r); k != "" {
+				return k
+			}
+		}
+		return ""
+	}
+}
+
+// ParsePrefixes parses trusted proxies given as CIDRs or single addresses.
+func ParsePrefixes(values ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func remoteIP(addr string) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return ip.Unmap(), nil
+}
+
+// seconds rounds d up to whole seconds, as the headers expect.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestByIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+
+	tests := []struct {
+		name    string
+		trusted []netip.Prefix
+		remote  string
+		xff     []string
+		want    string
+	}{
+		{name: "direct", trusted: trusted, remote: "203.0.113.7:4000", want: "ip:203.0.113.7"},
+		{name: "untrusted proxy is not believed", trusted: trusted, remote: "203.0.113.7:4000", xff: []string{"198.51.100.1"}, want: "ip:203.0.113.7"},
+		{name: "nothing trusted", remote: "10.0.0.1:4000", xff: []string{"198.51.100.1"}, want: "ip:10.0.0.1"},
+		{name: "trusted proxy", trusted: trusted, remote: "10.0.0.1:4000", xff: []string{"198.51.100.1"}, want: "ip:198.51.100.1"},
+		{name: "spoofed hops left of the client", trusted: trusted, remote: "10.0.0.1:4000", xff: []string{"1.2.3.4, 198.51.100.1"}, want: "ip:198.51.100.1"},
+		{name: "trusted hops skipped", trusted: trusted, remote: "10.0.0.1:4000", xff: []string{"198.51.100.1, 10.0.0.2,10.0.0.3"}, want: "ip:198.51.100.1"},
+		{name: "several headers", trusted: trusted, remote: "10.0.0.1:4000", xff: []string{"1.2.3.4", "198.51.100.1, 10.0.0.2"}, want: "ip:198.51.100.1"},
+		{name: "all hops trusted", trusted: trusted, remote: "10.0.0.1:4000", xff: []string{"10.0.0.3, 10.0.0.2"}, want: "ip:10.0.0.3"},
+		{name: "garbage hop stops the walk", trusted: trusted, remote: "10.0.0.1:4000", xff: []string{"198.51.100.1, unknown"}, want: "ip:10.0.0.1"},
+		{name: "no header", trusted: trusted, remote: "10.0.0.1:4000", want: "ip:10.0.0.1"},
+		{name: "ipv6", trusted: trusted, remote: "[fd00::1]:4000", xff: []string{"2001:db8::1"}, want: "ip:2001:db8::1"},
+		{name: "ipv4 mapped", trusted: trusted, remote: "[::ffff:10.0.0.1]:4000", xff: []string{"::ffff:198.51.100.1"}, want: "ip:198.51.100.1"},
+		{name: "remote without port", trusted: trusted, remote: "203.0.113.7", want: "ip:203.0.113.7"},
+		{name: "bad remote", trusted: trusted, remote: "pipe", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := ByIP(tt.trusted)(r); got != tt.want {
+				t.Errorf("ByIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"my-go-app/redis/cahce/storage"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// FixedWindow allows Rate requests per Period, counted in windows that
+	// start with the first request. Up to twice the rate can pass around
+	// a window boundary.
+	FixedWindow Algorithm = "fixed_window"
+	// SlidingLog remembers every request of the last Period. It is exact
+	// but stores one sorted set member per request.
+	SlidingLog Algorithm = "sliding_log"
+	// SlidingWindow approximates SlidingLog from two fixed window counts.
+	SlidingWindow Algorithm = "sliding_window"
+	// TokenBucket refills Burst tokens at Rate per Period.
+	TokenBucket Algorithm = "token_bucket"
+	// GCRA spaces requests Period/Rate apart, allowing Burst at once. It
+	// stores a single timestamp per key.
+	GCRA Algorithm = "gcra"
+
+	defaultPrefix = "rate"
+)
+
+var (
+	ErrInvalidLimit = errors.New("ratelimit: rate and period must be positive")
+
+	scripts = map[Algorithm]*redis.Script{
+		FixedWindow:   fixedWindowScript,
+		SlidingLog:    slidingLogScript,
+		SlidingWindow: slidingWindowScript,
+		TokenBucket:   tokenBucketScript,
+		GCRA:          gcraScript,
+	}
+)
+
+type (
+	Algorithm string
+
+	// Limit allows Rate requests per Period. Burst only applies to
+	// TokenBucket and GCRA and defaults to Rate.
+	Limit struct {
+		Rate   int           `yaml:"rate"`
+		Period time.Duration `yaml:"period"`
+		Burst  int           `yaml:"burst"`
+	}
+
+	Config struct {
+		Algorithm Algorithm `yaml:"algorithm"`
+		// Prefix namespaces the limiter's keys, "rate" by default.
+		Prefix string `yaml:"prefix"`
+		// Default applies to keys without an entry in Keys.
+		Default Limit            `yaml:"default"`
+		Keys    map[string]Limit `yaml:"keys"`
+	}
+
+	// Result is the outcome of one check. RetryAfter is how long to wait
+	// before the same request could pass, and is negative when it never
+	// can. ResetAfter is how long until the limit is fully available again.
+	Result struct {
+		Allowed    bool
+		Limit      int
+		Remaining  int
+		RetryAfter time.Duration
+		ResetAfter time.Duration
+	}
+
+	// Limiter checks limits atomically in redis with one script call per
+	// check, so all instances share the same counts.
+	Limiter struct {
+		db     redis.UniversalClient
+		cnf    Config
+		script *redis.Script
+	}
+)
+
+func PerSecond(rate int) Limit { return Limit{Rate: rate, Period: time.Second} }
+
+func PerMinute(rate int) Limit { return Limit{Rate: rate, Period: time.Minute} }
+
+func PerHour(rate int) Limit { return Limit{Rate: rate, Period: time.Hour} }
+
+func New(db redis.UniversalClient, cnf Config) (*Limiter, error) {
+	script, ok := scripts[cnf.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("ratelimit: unknown algorithm %q", cnf.Algorithm)
+	}
+	if cnf.Prefix == "" {
+		cnf.Prefix = defaultPrefix
+	}
+	if err := cnf.Default.validate(); err != nil {
+		return nil, err
+	}
+	for key, limit := range cnf.Keys {
+		if err := limit.validate(); err != nil {
+			return nil, fmt.Errorf("%w: key %q", err, key)
+		}
+	}
+
+	return &Limiter{db: db, cnf: cnf, script: script}, nil
+}
+
+// Limit returns the limit configured for key.
+func (l *Limiter) Limit(key string) Limit {
+	if limit, ok := l.cnf.Keys[key]; ok {
+		return limit
+	}
+	return l.cnf.Default
+}
+
+// Allow checks and counts one request for key.
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN checks and counts a request of cost n for key. Denied requests
+// are not counted.
+func (l *Limiter) AllowN(ctx context.Context, key string, n int) (Result, error) {
+	return l.AllowLimit(ctx, key, l.Limit(key), n)
+}
+
+// AllowLimit is AllowN with a limit chosen by the caller, e.g. per plan.
+// Checking one key under different limits gives inconsistent results.
+func (l *Limiter) AllowLimit(ctx context.Context, key string, limit Limit, n int) (Result, error) {
+	if err := limit.validate(); err != nil {
+		return Result{}, err
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Rate
+	}
+
+	token, err := storage.NewToken()
+	if err != nil {
+		return Result{}, err
+	}
+
+	keys := []string{l.cnf.Prefix + ":" + string(l.cnf.Algorithm) + ":" + key}
+	args := []interface{}{limit.Rate, limit.Period.Milliseconds(), burst, n, token}
+	res, err := l.script.Run(ctx, l.db, keys, args...).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(res) != 4 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected script result %v", res)
+	}
+
+	result := Result{
+		Allowed:    res[0] == 1,
+		Limit:      limit.Rate,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+		ResetAfter: time.Duration(res[3]) * time.Millisecond,
+	}
+	if res[2] < 0 {
+		result.RetryAfter = -1
+	}
+	return result, nil
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 || l.Period < time.Millisecond {
+		return ErrInvalidLimit
+	}
+	return nil
+}
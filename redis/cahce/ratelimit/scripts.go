@@ -0,0 +1,172 @@
+package ratelimit
+
+import "github.com/go-redis/redis/v8"
+
+// Every script takes the limiter key as KEYS[1] and ARGV rate, period in
+// milliseconds, burst and cost, and returns {allowed, remaining,
+// retry_after_ms, reset_after_ms}. retry_after_ms is -1 when the cost can
+// never be allowed. Time is read from redis, so instances with skewed
+// clocks still agree.
+
+// now is the redis server time in fractional milliseconds.
+const now = `
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + tonumber(t[2]) / 1000
+`
+
+// fixedWindowScript counts requests in windows of period starting with the
+// first request.
+var fixedWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local rate, period, cost = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[4])
+if cost > rate then
+	return {0, 0, -1, 0}
+end
+
+local count = tonumber(redis.call("GET", key) or "0")
+local ttl = redis.call("PTTL", key)
+if ttl < 0 then
+	ttl = period
+end
+if count + cost > rate then
+	return {0, math.max(rate - count, 0), ttl, ttl}
+end
+
+count = redis.call("INCRBY", key, cost)
+if redis.call("PTTL", key) < 0 then
+	redis.call("PEXPIRE", key, period)
+end
+return {1, rate - count, 0, ttl}
+`)
+
+// slidingLogScript keeps the time of every request of the last period in
+// a sorted set. ARGV[5] makes the members of this call unique.
+var slidingLogScript = redis.NewScript(now + `
+local key = KEYS[1]
+local rate, period, cost = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[4])
+if cost > rate then
+	return {0, 0, -1, 0}
+end
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - period)
+local count = redis.call("ZCARD", key)
+
+local function expiry(index)
+	local entry = redis.call("ZRANGE", key, index, index, "WITHSCORES")
+	if entry[2] == nil then
+		return 0
+	end
+	return math.max(math.ceil(tonumber(entry[2]) + period - now), 0)
+end
+
+if count + cost > rate then
+	local wait = expiry(count + cost - rate - 1)
+	return {0, rate - count, wait, expiry(-1)}
+end
+
+for i = 1, cost do
+	redis.call("ZADD", key, now, ARGV[5] .. ":" .. i)
+end
+redis.call("PEXPIRE", key, math.ceil(period))
+return {1, rate - count - cost, 0, math.ceil(period)}
+`)
+
+// slidingWindowScript estimates the requests of the last period from the
+// counts of the current and previous fixed windows, weighting the previous
+// one by how much of it still overlaps.
+var slidingWindowScript = redis.NewScript(now + `
+local key = KEYS[1]
+local rate, period, cost = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[4])
+if cost > rate then
+	return {0, 0, -1, 0}
+end
+
+local window = math.floor(now / period)
+local state = redis.call("HMGET", key, "window", "current", "previous")
+local last = tonumber(state[1])
+local current, previous = tonumber(state[2]) or 0, tonumber(state[3]) or 0
+if last == nil or last < window - 1 then
+	current, previous = 0, 0
+elseif last == window - 1 then
+	current, previous = 0, current
+end
+
+local elapsed = now - window * period
+local left = period - elapsed
+local estimate = previous * (1 - elapsed / period) + current
+
+if estimate + cost > rate then
+	local wait
+	if current + cost <= rate and previous > 0 then
+		-- wait until enough of the previous window has slid out
+		local overlap = (rate - cost - current) / previous
+		wait = (1 - overlap) * period - elapsed
+	else
+		-- wait for the next window, where current becomes previous
+		local overlap = 0
+		if current > 0 then
+			overlap = (rate - cost) / current
+		end
+		wait = left + (1 - overlap) * period
+	end
+	return {0, math.max(math.floor(rate - estimate), 0), math.max(math.ceil(wait), 1), math.ceil(left)}
+end
+
+current = current + cost
+redis.call("HSET", key, "window", window, "current", current, "previous", previous)
+redis.call("PEXPIRE", key, math.ceil(left + period))
+return {1, math.floor(rate - estimate - cost), 0, math.ceil(left)}
+`)
+
+// tokenBucketScript refills burst tokens at rate per period and takes cost
+// tokens per request.
+var tokenBucketScript = redis.NewScript(now + `
+local key = KEYS[1]
+local rate, period, burst, cost = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+if cost > burst then
+	return {0, 0, -1, 0}
+end
+
+local refill = rate / period
+local state = redis.call("HMGET", key, "tokens", "at")
+local tokens = tonumber(state[1]) or burst
+local at = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(now - at, 0) * refill)
+
+local allowed, wait = 0, 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+else
+	wait = math.ceil((cost - tokens) / refill)
+end
+
+redis.call("HSET", key, "tokens", tokens, "at", now)
+local full = math.ceil((burst - tokens) / refill)
+redis.call("PEXPIRE", key, math.max(full, 1))
+return {allowed, math.floor(tokens), wait, full}
+`)
+
+// gcraScript implements the generic cell rate algorithm: it stores the
+// theoretical arrival time of the next request and allows a request when
+// that time is at most burst emission intervals ahead of now.
+var gcraScript = redis.NewScript(now + `
+local key = KEYS[1]
+local rate, period, burst, cost = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+if cost > burst then
+	return {0, 0, -1, 0}
+end
+
+local emission = period / rate
+local tolerance = emission * burst
+local tat = math.max(tonumber(redis.call("GET", key) or now), now)
+local newTat = tat + cost * emission
+
+local allowAt = newTat - tolerance
+if allowAt > now then
+	return {0, math.max(math.floor((tolerance - (tat - now)) / emission), 0), math.ceil(allowAt - now), math.ceil(tat - now)}
+end
+
+redis.call("SET", key, string.format("%.3f", newTat), "PX", math.max(math.ceil(newTat - now), 1))
+return {1, math.floor((tolerance - (newTat - now)) / emission), 0, math.ceil(newTat - now)}
+`)
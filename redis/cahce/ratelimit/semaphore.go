@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"my-go-app/redis/cahce/storage"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultSemaphorePrefix = "sem"
+	defaultLeaseTTL        = 30 * time.Second
+	defaultRetryMin        = 10 * time.Millisecond
+	defaultRetryMax        = 500 * time.Millisecond
+)
+
+var ErrSaturated = errors.New("ratelimit: semaphore is saturated")
+
+// Leases are members of a sorted set scored by when they expire, so that
+// expired leases of crashed holders are dropped by the next acquisition.
+
+// acquireScript adds lease ARGV[3] expiring in ARGV[2] milliseconds to
+// KEYS[1] unless ARGV[1] leases are held already. It returns {acquired,
+// held, wait_ms}, wait_ms being when the earliest lease expires.
+var acquireScript = redis.NewScript(now + `
+local key = KEYS[1]
+local limit, ttl = tonumber(ARGV[1]), tonumber(ARGV[2])
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now)
+local held = redis.call("ZCARD", key)
+if held >= limit then
+	local first = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+	return {0, held, math.max(math.ceil(tonumber(first[2]) - now), 1)}
+end
+
+redis.call("ZADD", key, now + ttl, ARGV[3])
+if redis.call("PTTL", key) < ttl then
+	redis.call("PEXPIRE", key, ttl)
+end
+return {1, held + 1, 0}
+`)
+
+// heldScript counts the leases of KEYS[1] that have not expired.
+var heldScript = redis.NewScript(now + `
+return redis.call("ZCOUNT", KEYS[1], "(" .. now, "+inf")
+`)
+
+// extendScript moves the expiry of lease ARGV[2] to ARGV[1] milliseconds
+// from now, returning 0 when the lease expired already.
+var extendScript = redis.NewScript(now + `
+local key, ttl = KEYS[1], tonumber(ARGV[1])
+local expiry = redis.call("ZSCORE", key, ARGV[2])
+if not expiry or tonumber(expiry) <= now then
+	return 0
+end
+
+redis.call("ZADD", key, "XX", now + ttl, ARGV[2])
+if redis.call("PTTL", key) < ttl then
+	redis.call("PEXPIRE", key, ttl)
+end
+return 1
+`)
+
+type (
+	SemaphoreConfig struct {
+		// Prefix namespaces the semaphore's keys, "sem" by default.
+		Prefix string `yaml:"prefix"`
+		// Limit is how many leases may be held at once per name.
+		Limit int `yaml:"limit"`
+		// TTL is how long a lease outlives a holder that crashed, 30s by
+		// default.
+		TTL time.Duration `yaml:"ttl"`
+		// AutoRenew extends held leases every TTL/3 until they are
+		// released, for holders that may run longer than TTL.
+		AutoRenew bool `yaml:"auto_renew"`
+		// RetryMin and RetryMax bound the jittered exponential backoff of
+		// Acquire, 10ms and 500ms by default.
+		RetryMin time.Duration `yaml:"retry_min"`
+		RetryMax time.Duration `yaml:"retry_max"`
+	}
+
+	// Semaphore limits in-flight operations across all instances, per
+	// name, e.g. per downstream.
+	Semaphore struct {
+		db  redis.UniversalClient
+		cnf SemaphoreConfig
+	}
+
+	// Lease is one held slot. It must be released.
+	Lease struct {
+		sem   *Semaphore
+		key   string
+		token string
+
+		// renewal is nil without AutoRenew.
+		renewal *storage.Renewal
+	}
+)
+
+func NewSemaphore(db redis.UniversalClient, cnf SemaphoreConfig) (*Semaphore, error) {
+	if cnf.Limit <= 0 {
+		return nil, errors.New("ratelimit: semaphore limit must be positive")
+	}
+	if cnf.Prefix == "" {
+		cnf.Prefix = defaultSemaphorePrefix
+	}
+	if cnf.TTL <= 0 {
+		cnf.TTL = defaultLeaseTTL
+	}
+	if cnf.RetryMin <= 0 {
+		cnf.RetryMin = defaultRetryMin
+	}
+	if cnf.RetryMax < cnf.RetryMin {
+		cnf.RetryMax = max(defaultRetryMax, cnf.RetryMin)
+	}
+	return &Semaphore{db: db, cnf: cnf}, nil
+}
+
+// TryAcquire takes a lease on name, or returns ErrSaturated when all of
+// them are held.
+func (s *Semaphore) TryAcquire(ctx context.Context, name string) (*Lease, error) {
+	lease, _, err := s.tryAcquire(ctx, name)
+	return lease, err
+}
+
+// Acquire waits for a lease on name until ctx is done. It retries with
+// backoff, but no later than the earliest held lease expires.
+func (s *Semaphore) Acquire(ctx context.Context, name string) (*Lease, error) {
+	b := storage.Backoff{Min: s.cnf.RetryMin, Max: s.cnf.RetryMax}
+	for {
+		lease, expiry, err := s.tryAcquire(ctx, name)
+		if !errors.Is(err, ErrSaturated) {
+			return lease, err
+		}
+		if err := b.Wait(ctx, expiry); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Held is how many leases on name are held right now.
+func (s *Semaphore) Held(ctx context.Context, name string) (int, error) {
+	return heldScript.Run(ctx, s.db, []string{s.key(name)}).Int()
+}
+
+// Release gives the slot back.
+func (l *Lease) Release(ctx context.Context) error {
+	if l.renewal != nil {
+		l.renewal.Stop()
+	}
+	return l.sem.db.ZRem(ctx, l.key, l.token).Err()
+}
+
+// extend moves the lease's expiry to TTL from now, returning
+// storage.ErrLockLost when it expired already.
+func (l *Lease) extend(ctx context.Context) (time.Time, error) {
+	ttl := l.sem.cnf.TTL
+	start := time.Now()
+	ok, err := extendScript.Run(ctx, l.sem.db, []string{l.key}, ttl.Milliseconds(), l.token).Bool()
+	switch {
+	case err != nil:
+		return time.Time{}, err
+	case !ok:
+		return time.Time{}, storage.ErrLockLost
+	}
+	return start.Add(ttl), nil
+}
+
+func (s *Semaphore) tryAcquire(ctx context.Context, name string) (*Lease, time.Duration, error) {
+	token, err := storage.NewToken()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	key := s.key(name)
+	start := time.Now()
+	res, err := acquireScript.Run(ctx, s.db, []string{key}, s.cnf.Limit, s.cnf.TTL.Milliseconds(), token).Int64Slice()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(res) != 3 {
+		return nil, 0, fmt.Errorf("ratelimit: unexpected script result %v", res)
+	}
+	if res[0] != 1 {
+		return nil, time.Duration(res[2]) * time.Millisecond, ErrSaturated
+	}
+
+	lease := &Lease{sem: s, key: key, token: token}
+	if s.cnf.AutoRenew {
+		lease.renewal = storage.Renew("lease on "+key, s.cnf.TTL/3, start.Add(s.cnf.TTL), lease.extend)
+	}
+	return lease, 0, nil
+}
+
+func (s *Semaphore) key(name string) string {
+	return s.cnf.Prefix + ":" + name
+}
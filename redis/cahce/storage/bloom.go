@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"math"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// bloomBatch bounds the number of items sent in one pipeline by Add.
+const bloomBatch = 1000
+
+type (
+	BloomConfig struct {
+		// Key is the redis string holding the filter bits.
+		Key string `yaml:"key"`
+		// Capacity is the number of items the filter is sized for.
+		Capacity int `yaml:"capacity"`
+		// FalsePositive is the target false positive rate at Capacity.
+		FalsePositive float64 `yaml:"false_positive"`
+	}
+
+	// Bloom is a bloom filter kept in a redis bitmap, shared by every
+	// instance. Items cannot be removed, so deleted items keep testing
+	// positive until the filter is rebuilt.
+	Bloom struct {
+		db  redis.UniversalClient
+		key string
+		m   uint64
+		k   uint64
+	}
+)
+
+// NewBloom sizes the filter for cnf.Capacity items at cnf.FalsePositive,
+// defaulting to one million items at 1%.
+func NewBloom(db redis.UniversalClient, cnf BloomConfig) *Bloom {
+	if cnf.Capacity <= 0 {
+		cnf.Capacity = 1_000_000
+	}
+	if cnf.FalsePositive <= 0 || cnf.FalsePositive >= 1 {
+		cnf.FalsePositive = 0.01
+	}
+
+	n := float64(cnf.Capacity)
+	m := math.Ceil(-n * math.Log(cnf.FalsePositive) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+
+	return &Bloom{db: db, key: cnf.Key, m: uint64(m), k: uint64(k)}
+}
+
+// Add records items in the filter.
+func (b *Bloom) Add(ctx context.Context, items ...string) error {
+	for len(items) > 0 {
+		batch := items
+		if len(batch) > bloomBatch {
+			batch = batch[:bloomBatch]
+		}
+		items = items[len(batch):]
+
+		_, err := b.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for _, item := range batch {
+				for _, pos := range b.positions(item) {
+					p.SetBit(ctx, b.key, int64(pos), 1)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MightContain reports false only for items that were never added. It
+// fails open: when redis cannot be asked, every item might be present.
+func (b *Bloom) MightContain(ctx context.Context, item string) bool {
+	positions := b.positions(item)
+	cmds := make([]*redis.IntCmd, len(positions))
+
+	_, err := b.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, pos := range positions {
+			cmds[i] = p.GetBit(ctx, b.key, int64(pos))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("bloom %s: %v", b.key, err)
+		return true
+	}
+
+	for _, cmd := range cmds {
+		if cmd.Val() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// positions derives the k bit offsets of item by double hashing.
+func (b *Bloom) positions(item string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+
+	positions := make([]uint64, b.k)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % b.m
+	}
+	return positions
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"my-go-app/redis/cahce/metrics"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+var ErrBreakerOpen = errors.New("redis circuit breaker is open")
+
+type (
+	BreakerState int
+
+	BreakerConfig struct {
+		// FailureThreshold is the number of consecutive failed commands
+		// that opens the breaker.
+		FailureThreshold int `yaml:"failure_threshold"`
+		// OpenTimeout is how long the breaker stays open before letting
+		// probe commands through.
+		OpenTimeout time.Duration `yaml:"open_timeout"`
+		// HalfOpenRequests is the number of probes that must succeed to
+		// close the breaker again.
+		HalfOpenRequests int `yaml:"half_open_requests"`
+	}
+
+	// Breaker is a redis.Hook that fails commands fast while redis is
+	// unavailable instead of letting each one wait out timeouts and retries.
+	Breaker struct {
+		name string
+		cnf  BreakerConfig
+
+		mu        sync.Mutex
+		state     BreakerState
+		failures  int
+		openedAt  time.Time
+		probes    int
+		successes int
+	}
+
+	probeKey struct{}
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "open"
+	}
+}
+
+func NewBreaker(name string, cnf BreakerConfig) *Breaker {
+	if cnf.FailureThreshold <= 0 {
+		cnf.FailureThreshold = 5
+	}
+	if cnf.OpenTimeout <= 0 {
+		cnf.OpenTimeout = 10 * time.Second
+	}
+	if cnf.HalfOpenRequests <= 0 {
+		cnf.HalfOpenRequests = 1
+	}
+
+	b := &Breaker{name: name, cnf: cnf}
+	metrics.SetBreakerState(name, int(BreakerClosed))
+	return b
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Open reports whether callers should skip redis altogether. It turns
+// false once the breaker is ready to probe again.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == BreakerOpen && time.Since(b.openedAt) < b.cnf.OpenTimeout
+}
+
+func (b *Breaker) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return b.before(ctx)
+}
+
+func (b *Breaker) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	b.after(ctx, cmd.Err())
+	return nil
+}
+
+func (b *Breaker) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return b.before(ctx)
+}
+
+func (b *Breaker) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if failed(cmd.Err()) {
+			err = cmd.Err()
+			break
+		}
+	}
+	b.after(ctx, err)
+	return nil
+}
+
+func (b *Breaker) before(ctx context.Context) (context.Context, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.cnf.OpenTimeout {
+			return ctx, ErrBreakerOpen
+		}
+		b.setState(BreakerHalfOpen)
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.cnf.HalfOpenRequests {
+			return ctx, ErrBreakerOpen
+		}
+		b.probes++
+		return context.WithValue(ctx, probeKey{}, true), nil
+	}
+	return ctx, nil
+}
+
+func (b *Breaker) after(ctx context.Context, err error) {
+	if errors.Is(err, ErrBreakerOpen) {
+		return
+	}
+	probe, _ := ctx.Value(probeKey{}).(bool)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe && errors.Is(err, context.Canceled) {
+		// the probe told us nothing, let another one through
+		b.probes--
+		return
+	}
+
+	if !failed(err) {
+		switch {
+		case b.state == BreakerHalfOpen && probe:
+			b.successes++
+			if b.successes >= b.cnf.HalfOpenRequests {
+				b.setState(BreakerClosed)
+			}
+		case b.state == BreakerClosed:
+			b.failures = 0
+		}
+		return
+	}
+
+	switch {
+	case b.state == BreakerHalfOpen && probe:
+		b.setState(BreakerOpen)
+	case b.state == BreakerClosed:
+		b.failures++
+		if b.failures >= b.cnf.FailureThreshold {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// setState must be called with mu held.
+func (b *Breaker) setState(state BreakerState) {
+	b.state = state
+	b.failures, b.probes, b.successes = 0, 0, 0
+	if state == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+	metrics.SetBreakerState(b.name, int(state))
+}
+
+// failed tells connection problems and timeouts apart from redis.Nil,
+// error replies and callers giving up, none of which say redis is down.
+func failed(err error) bool {
+	if err == nil || err == redis.Nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var reply redis.Error
+	return !errors.As(err, &reply)
+}
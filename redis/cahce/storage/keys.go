@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultGenerationRefresh = time.Second
+	purgeBatch               = 1000
+)
+
+type (
+	// KeySpace describes where an entity's keys live, e.g.
+	// "cards:t:acme:card:v1" for Service "cards", Tenant "acme",
+	// Entity "card" and Version 1.
+	KeySpace struct {
+		Service string `yaml:"service"`
+		Entity  string `yaml:"entity"`
+		Version int    `yaml:"version"`
+		Tenant  string `yaml:"tenant"`
+	}
+
+	// Namespace builds keys in a KeySpace. Every key also carries the
+	// namespace generation, so bumping it orphans all existing keys at
+	// once. Bump then purges the old keys in the background, since keys
+	// written without a ttl would never expire.
+	Namespace struct {
+		db      redis.UniversalClient
+		space   KeySpace
+		refresh time.Duration
+
+		mu     sync.Mutex
+		gen    int64
+		loaded time.Time
+	}
+)
+
+func (s KeySpace) Prefix() string {
+	parts := []string{s.Service}
+	if s.Tenant != "" {
+		parts = append(parts, "t", s.Tenant)
+	}
+	parts = append(parts, s.Entity, "v"+strconv.Itoa(s.Version))
+	return strings.Join(parts, ":")
+}
+
+// WithTenant returns the same key space scoped to tenant.
+func (s KeySpace) WithTenant(tenant string) KeySpace {
+	s.Tenant = tenant
+	return s
+}
+
+// NewNamespace returns a Namespace that re-reads its generation from redis
+// at most every refresh, so bumps by other instances are picked up within
+// that interval. Zero means one second.
+func NewNamespace(db redis.UniversalClient, space KeySpace, refresh time.Duration) *Namespace {
+	if refresh <= 0 {
+		refresh = defaultGenerationRefresh
+	}
+	return &Namespace{db: db, space: space, refresh: refresh}
+}
+
+func (n *Namespace) Space() KeySpace {
+	return n.space
+}
+
+// Key returns the full redis key for id in the current generation.
+func (n *Namespace) Key(ctx context.Context, id string) (string, error) {
+	gen, err := n.generation(ctx)
+	if err != nil {
+		return "", err
+	}
+	return n.space.Prefix() + ":g" + strconv.FormatInt(gen, 10) + ":" + id, nil
+}
+
+// Bump invalidates every key of the namespace by moving to a new
+// generation, then purges the older generations in the background.
+func (n *Namespace) Bump(ctx context.Context) (int64, error) {
+	gen, err := n.db.Incr(ctx, n.generationKey()).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	n.mu.Lock()
+	n.gen, n.loaded = gen, time.Now()
+	n.mu.Unlock()
+
+	go func() {
+		if _, err := n.Purge(context.WithoutCancel(ctx), gen); err != nil {
+			log.Printf("namespace %s: purge before g%d: %v", n.space.Prefix(), gen, err)
+		}
+	}()
+	return gen, nil
+}
+
+// Purge deletes the keys of every generation before gen, e.g. the ones a
+// Bump left behind without a ttl, and returns how many it deleted. It
+// scans the whole keyspace, every master of a cluster.
+func (n *Namespace) Purge(ctx context.Context, gen int64) (int64, error) {
+	prefix := n.space.Prefix() + ":g"
+	purge := func(ctx context.Context, db redis.UniversalClient) (int64, error) {
+		var deleted int64
+		batch := make([]string, 0, purgeBatch)
+		flush := func() error {
+			d, err := DeleteKeys(ctx, db, batch...)
+			deleted += d
+			batch = batch[:0]
+			return err
+		}
+
+		iter := db.Scan(ctx, 0, prefix+"*", purgeBatch).Iterator()
+		for iter.Next(ctx) {
+			// the generation key itself does not parse
+			g, _, _ := strings.Cut(strings.TrimPrefix(iter.Val(), prefix), ":")
+			if old, err := strconv.ParseInt(g, 10, 64); err != nil || old >= gen {
+				continue
+			}
+			batch = append(batch, iter.Val())
+			if len(batch) == purgeBatch {
+				if err := flush(); err != nil {
+					return deleted, err
+				}
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return deleted, err
+		}
+		return deleted, flush()
+	}
+
+	cluster, ok := n.db.(*redis.ClusterClient)
+	if !ok {
+		return purge(ctx, n.db)
+	}
+	var deleted atomic.Int64
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		d, err := purge(ctx, master)
+		deleted.Add(d)
+		return err
+	})
+	return deleted.Load(), err
+}
+
+func (n *Namespace) generation(ctx context.Context) (int64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.loaded.IsZero() && time.Since(n.loaded) < n.refresh {
+		return n.gen, nil
+	}
+
+	gen, err := n.db.Get(ctx, n.generationKey()).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	n.gen, n.loaded = gen, time.Now()
+	return gen, nil
+}
+
+func (n *Namespace) generationKey() string {
+	return n.space.Prefix() + ":gen"
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultLockTTL      = 10 * time.Second
+	defaultLockRetryMin = 50 * time.Millisecond
+	defaultLockRetryMax = time.Second
+	defaultNodeTimeout  = 50 * time.Millisecond
+)
+
+var (
+	ErrLockNotAcquired = errors.New("lock is held by someone else")
+	ErrLockLost        = errors.New("lock was lost")
+
+	// releaseScript deletes the lock only while the caller's token holds it.
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+	// extendScript resets the lock's ttl only while the caller's token holds it.
+	extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+type (
+	LockConfig struct {
+		// TTL is how long a lock outlives a holder that stops renewing it,
+		// 10s by default.
+		TTL time.Duration `yaml:"ttl"`
+		// AutoRenew extends held locks every TTL/3 until they are released.
+		AutoRenew bool `yaml:"auto_renew"`
+		// RetryMin and RetryMax bound the jittered exponential backoff of
+		// Lock between attempts, 50ms and 1s by default.
+		RetryMin time.Duration `yaml:"retry_min"`
+		RetryMax time.Duration `yaml:"retry_max"`
+		// NodeTimeout bounds each node's reply in Redlock mode, so that a
+		// dead node does not eat up the lock's validity. 50ms by default.
+		NodeTimeout time.Duration `yaml:"node_timeout"`
+	}
+
+	// Locker hands out locks held on one redis, or on a majority of
+	// independent masters in Redlock mode.
+	Locker struct {
+		dbs []redis.UniversalClient
+		cnf LockConfig
+	}
+
+	// Lock is a held lock. It is valid until its ttl runs out, Release is
+	// called or, with AutoRenew, Lost is closed.
+	Lock struct {
+		locker *Locker
+		key    string
+		token  string
+
+		mu    sync.Mutex
+		until time.Time
+
+		// renewal is nil without AutoRenew.
+		renewal *Renewal
+	}
+)
+
+// NewLocker returns a Locker for locks on db. With Sentinel or replicas
+// the lock can be lost on failover, as writes are replicated
+// asynchronously.
+func NewLocker(db redis.UniversalClient, cnf LockConfig) *Locker {
+	return newLocker([]redis.UniversalClient{db}, cnf)
+}
+
+// NewRedlock returns a Locker in Redlock mode: a lock is held when it is
+// set on a majority of dbs, which must be independent masters, within its
+// ttl.
+func NewRedlock(dbs []redis.UniversalClient, cnf LockConfig) *Locker {
+	return newLocker(dbs, cnf)
+}
+
+func newLocker(dbs []redis.UniversalClient, cnf LockConfig) *Locker {
+	if cnf.TTL <= 0 {
+		cnf.TTL = defaultLockTTL
+	}
+	if cnf.RetryMin <= 0 {
+		cnf.RetryMin = defaultLockRetryMin
+	}
+	if cnf.RetryMax < cnf.RetryMin {
+		cnf.RetryMax = max(defaultLockRetryMax, cnf.RetryMin)
+	}
+	if cnf.NodeTimeout <= 0 {
+		cnf.NodeTimeout = defaultNodeTimeout
+	}
+	return &Locker{dbs: dbs, cnf: cnf}
+}
+
+// TryLock takes the lock on key once, returning ErrLockNotAcquired when
+// someone else holds it.
+func (l *Locker) TryLock(ctx context.Context, key string) (*Lock, error) {
+	token, err := NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	n, err := l.each(ctx, func(ctx context.Context, db redis.UniversalClient) (bool, error) {
+		return db.SetNX(ctx, key, token, l.cnf.TTL).Result()
+	})
+	until := start.Add(l.cnf.TTL - l.drift())
+	if n < l.quorum() || !time.Now().Before(until) {
+		// nodes that did set it would otherwise block everyone for a ttl
+		if n > 0 {
+			l.release(context.WithoutCancel(ctx), key, token)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrLockNotAcquired
+	}
+
+	lock := &Lock{locker: l, key: key, token: token, until: until}
+	if l.cnf.AutoRenew {
+		lock.renewal = Renew("lock "+key, l.cnf.TTL/3, until, func(ctx context.Context) (time.Time, error) {
+			err := lock.Extend(ctx, l.cnf.TTL)
+			return lock.Until(), err
+		})
+	}
+	return lock, nil
+}
+
+// Lock waits for the lock on key, retrying with backoff until it is
+// acquired or ctx is done.
+func (l *Locker) Lock(ctx context.Context, key string) (*Lock, error) {
+	b := Backoff{Min: l.cnf.RetryMin, Max: l.cnf.RetryMax}
+	for {
+		lock, err := l.TryLock(ctx, key)
+		if !errors.Is(err, ErrLockNotAcquired) {
+			return lock, err
+		}
+		if err := b.Wait(ctx, 0); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WithLock runs fn while holding the lock on key, waiting for it first.
+// fn's context is cancelled when the lock is lost, so with AutoRenew fn
+// may run for longer than the ttl.
+func (l *Locker) WithLock(ctx context.Context, key string, fn func(ctx context.Context) error) error {
+	lock, err := l.Lock(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Release(context.WithoutCancel(ctx)); err != nil {
+			log.Printf("lock: release %s: %v", key, err)
+		}
+	}()
+
+	fnCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if !l.cnf.AutoRenew {
+		var cancelAt context.CancelFunc
+		fnCtx, cancelAt = context.WithDeadline(fnCtx, lock.Until())
+		defer cancelAt()
+	}
+	go func() {
+		select {
+		case <-lock.Lost():
+			cancel()
+		case <-fnCtx.Done():
+		}
+	}()
+
+	if err := fn(fnCtx); err != nil {
+		return err
+	}
+	select {
+	case <-lock.Lost():
+		return ErrLockLost
+	default:
+		return nil
+	}
+}
+
+func (k *Lock) Key() string { return k.key }
+
+// Until is when the lock expires unless it is extended.
+func (k *Lock) Until() time.Time {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.until
+}
+
+// Lost is closed once an automatic renewal finds the lock gone or cannot
+// renew it before it expires. Holders should stop working on what the
+// lock protects.
+func (k *Lock) Lost() <-chan struct{} {
+	if k.renewal == nil {
+		return nil
+	}
+	return k.renewal.Lost()
+}
+
+// Extend resets the lock's ttl to ttl, or returns ErrLockLost when it is no
+// longer held.
+func (k *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	l := k.locker
+	start := time.Now()
+	n, err := l.each(ctx, func(ctx context.Context, db redis.UniversalClient) (bool, error) {
+		res, err := extendScript.Run(ctx, db, []string{k.key}, k.token, ttl.Milliseconds()).Int()
+		return res == 1, err
+	})
+	until := start.Add(ttl - l.drift())
+	if n < l.quorum() || !time.Now().Before(until) {
+		if err != nil {
+			return err
+		}
+		return ErrLockLost
+	}
+
+	k.mu.Lock()
+	k.until = until
+	k.mu.Unlock()
+	return nil
+}
+
+// Release stops renewing the lock and deletes it unless someone else took
+// it over meanwhile.
+func (k *Lock) Release(ctx context.Context) error {
+	if k.renewal != nil {
+		k.renewal.Stop()
+	}
+	return k.locker.release(ctx, k.key, k.token)
+}
+
+func (l *Locker) release(ctx context.Context, key, token string) error {
+	_, err := l.each(ctx, func(ctx context.Context, db redis.UniversalClient) (bool, error) {
+		return true, releaseScript.Run(ctx, db, []string{key}, token).Err()
+	})
+	return err
+}
+
+// each runs fn on every node, concurrently in Redlock mode, and returns
+// how many returned true along with their errors.
+func (l *Locker) each(ctx context.Context, fn func(ctx context.Context, db redis.UniversalClient) (bool, error)) (int, error) {
+	if len(l.dbs) == 1 {
+		ok, err := fn(ctx, l.dbs[0])
+		if ok && err == nil {
+			return 1, nil
+		}
+		return 0, err
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		n    int
+		errs []error
+	)
+	for _, db := range l.dbs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, l.cnf.NodeTimeout)
+			defer cancel()
+
+			ok, err := fn(ctx, db)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				errs = append(errs, err)
+			case ok:
+				n++
+			}
+		}()
+	}
+	wg.Wait()
+
+	// errors only matter when they cost the quorum
+	if len(errs) > len(l.dbs)-l.quorum() {
+		return n, errors.Join(errs...)
+	}
+	return n, nil
+}
+
+func (l *Locker) quorum() int {
+	return len(l.dbs)/2 + 1
+}
+
+// drift allows for clock drift between the nodes, as Redlock suggests.
+func (l *Locker) drift() time.Duration {
+	if len(l.dbs) == 1 {
+		return 0
+	}
+	return l.cnf.TTL/100 + 2*time.Millisecond
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+)
+
+type (
+	PostgresConfig struct {
+		Host     string `yaml:"host"`
+		Port     int    `yaml:"port"`
+		User     string `yaml:"user"`
+		Password string `yaml:"password"`
+		DBName   string `yaml:"db_name"`
+		SSLMode  string `yaml:"ssl_mode"`
+	}
+)
+
+func NewPostgres(ctx context.Context, cnf PostgresConfig) (*sql.DB, error) {
+	sslMode := cnf.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		cnf.Host, cnf.Port, cnf.User, cnf.Password, cnf.DBName, sslMode)
+
+	db, err := sql.Open("postgres", psqlInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		fmt.Printf("failed to connect to postgres server %s\n", err.Error())
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
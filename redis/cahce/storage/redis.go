@@ -2,40 +2,209 @@ package storage
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
+	"my-go-app/redis/cahce/metrics"
+
 	"github.com/go-redis/redis/v8"
 )
 
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
 type (
+	// Config describes a standalone server (Addr), a Sentinel failover
+	// setup (Addrs of the sentinels plus MasterName) or a Cluster (Addrs
+	// of the seed nodes, where DB must be 0). An empty Mode means
+	// standalone.
 	Config struct {
+		Mode        string        `yaml:"mode"`
 		Addr        string        `yaml:"addr"`
+		Addrs       []string      `yaml:"addrs"`
+		MasterName  string        `yaml:"master_name"`
 		Password    string        `yaml:"password"`
 		User        string        `yaml:"user"`
 		DB          int           `yaml:"db"`
 		MaxRetries  int           `yaml:"max_retries"`
 		DialTimeout time.Duration `yaml:"dial_timeout"`
 		Timeout     time.Duration `yaml:"timeout"`
+
+		SentinelUser     string `yaml:"sentinel_user"`
+		SentinelPassword string `yaml:"sentinel_password"`
+
+		PoolSize     int           `yaml:"pool_size"`
+		MinIdleConns int           `yaml:"min_idle_conns"`
+		PoolTimeout  time.Duration `yaml:"pool_timeout"`
+
+		TLS TLSConfig `yaml:"tls"`
+
+		// TTL sets how long cached entries live, per route or entity.
+		TTL TTLConfig `yaml:"ttl"`
+	}
+
+	TLSConfig struct {
+		Enabled            bool   `yaml:"enabled"`
+		CAFile             string `yaml:"ca_file"`
+		CertFile           string `yaml:"cert_file"`
+		KeyFile            string `yaml:"key_file"`
+		ServerName         string `yaml:"server_name"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
 	}
 )
 
-func NewClient(ctx context.Context, cnf Config) (*redis.Client, error) {
-	db := redis.NewClient(&redis.Options{
-		Addr:         cnf.Addr,
-		Password:     cnf.Password,
-		DB:           cnf.DB,
-		Username:     cnf.User,
-		MaxRetries:   cnf.MaxRetries,
-		DialTimeout:  cnf.DialTimeout,
-		ReadTimeout:  cnf.Timeout,
-		WriteTimeout: cnf.Timeout,
-	})
+func NewClient(ctx context.Context, cnf Config) (redis.UniversalClient, error) {
+	tlsConfig, err := cnf.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var db redis.UniversalClient
+	switch cnf.Mode {
+	case "", ModeStandalone:
+		db = redis.NewClient(&redis.Options{
+			Addr:         cnf.Addr,
+			Password:     cnf.Password,
+			DB:           cnf.DB,
+			Username:     cnf.User,
+			MaxRetries:   cnf.MaxRetries,
+			DialTimeout:  cnf.DialTimeout,
+			ReadTimeout:  cnf.Timeout,
+			WriteTimeout: cnf.Timeout,
+			PoolSize:     cnf.PoolSize,
+			MinIdleConns: cnf.MinIdleConns,
+			PoolTimeout:  cnf.PoolTimeout,
+			TLSConfig:    tlsConfig,
+		})
+	case ModeSentinel:
+		if cnf.MasterName == "" || len(cnf.Addrs) == 0 {
+			return nil, errors.New("sentinel mode needs master_name and sentinel addrs")
+		}
+		db = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cnf.MasterName,
+			SentinelAddrs:    cnf.Addrs,
+			SentinelUsername: cnf.SentinelUser,
+			SentinelPassword: cnf.SentinelPassword,
+			Password:         cnf.Password,
+			DB:               cnf.DB,
+			Username:         cnf.User,
+			MaxRetries:       cnf.MaxRetries,
+			DialTimeout:      cnf.DialTimeout,
+			ReadTimeout:      cnf.Timeout,
+			WriteTimeout:     cnf.Timeout,
+			PoolSize:         cnf.PoolSize,
+			MinIdleConns:     cnf.MinIdleConns,
+			PoolTimeout:      cnf.PoolTimeout,
+			TLSConfig:        tlsConfig,
+		})
+	case ModeCluster:
+		if len(cnf.Addrs) == 0 {
+			return nil, errors.New("cluster mode needs addrs")
+		}
+		if cnf.DB != 0 {
+			return nil, errors.New("cluster mode only has db 0")
+		}
+		db = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cnf.Addrs,
+			Password:     cnf.Password,
+			Username:     cnf.User,
+			MaxRetries:   cnf.MaxRetries,
+			DialTimeout:  cnf.DialTimeout,
+			ReadTimeout:  cnf.Timeout,
+			WriteTimeout: cnf.Timeout,
+			PoolSize:     cnf.PoolSize,
+			MinIdleConns: cnf.MinIdleConns,
+			PoolTimeout:  cnf.PoolTimeout,
+			TLSConfig:    tlsConfig,
+		})
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", cnf.Mode)
+	}
 
 	if err := db.Ping(ctx).Err(); err != nil {
 		fmt.Printf("failed to connect to redis server %s/n", err.Error())
+		db.Close()
 		return nil, err
 	}
 
+	db.AddHook(metrics.Hook{})
+	if err := metrics.RegisterPool(cnf.name(), db); err != nil {
+		fmt.Printf("failed to register redis pool metrics %s\n", err.Error())
+	}
+
 	return db, nil
 }
+
+func (cnf Config) name() string {
+	if cnf.Mode == ModeSentinel {
+		return cnf.MasterName
+	}
+	if len(cnf.Addrs) > 0 {
+		return strings.Join(cnf.Addrs, ",")
+	}
+	return cnf.Addr
+}
+
+// Config builds the tls.Config for the client, or nil when TLS is off.
+func (t TLSConfig) Config() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// DeleteKeys deletes keys with one DEL per key, which keeps cluster clients
+// clear of cross-slot errors, and returns how many existed.
+func DeleteKeys(ctx context.Context, db redis.UniversalClient, keys ...string) (int64, error) {
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = p.Del(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, cmd := range cmds {
+		deleted += cmd.Val()
+	}
+	return deleted, nil
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+type (
+	// Backoff is a jittered exponential backoff from Min up to Max, for
+	// retrying to take something held by someone else.
+	Backoff struct {
+		Min, Max time.Duration
+
+		wait time.Duration
+	}
+
+	// Renewal keeps something with a ttl, such as a lock, alive until it is
+	// stopped.
+	Renewal struct {
+		name   string
+		extend func(ctx context.Context) (time.Time, error)
+		until  time.Time
+
+		stop     chan struct{}
+		stopOnce sync.Once
+		done     chan struct{}
+		lost     chan struct{}
+	}
+)
+
+// Wait sleeps for the next backoff, but no longer than limit when it is
+// positive, and doubles the backoff after.
+func (b *Backoff) Wait(ctx context.Context, limit time.Duration) error {
+	if b.wait == 0 {
+		b.wait = b.Min
+	}
+	d := b.wait
+	if limit > 0 {
+		d = min(d, limit)
+	}
+	b.wait = min(2*b.wait, b.Max)
+
+	// jitter keeps waiters from retrying in lockstep
+	select {
+	case <-time.After(d/2 + rand.N(d/2+1)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Renew calls extend every interval until Stop is called. extend returns
+// when what it extended expires next; it is given up on once extend
+// returns ErrLockLost or keeps failing past that time. name shows in logs.
+func Renew(name string, interval time.Duration, until time.Time, extend func(ctx context.Context) (time.Time, error)) *Renewal {
+	r := &Renewal{
+		name:   name,
+		extend: extend,
+		until:  until,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	go r.run(interval)
+	return r
+}
+
+// Lost is closed once the renewal gave up before Stop was called.
+func (r *Renewal) Lost() <-chan struct{} {
+	return r.lost
+}
+
+// Stop stops renewing and waits for an extension in flight.
+func (r *Renewal) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.done
+}
+
+func (r *Renewal) run(interval time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		until, err := r.extend(ctx)
+		cancel()
+		switch {
+		case err == nil:
+			r.until = until
+		case errors.Is(err, ErrLockLost) || !time.Now().Before(r.until):
+			log.Printf("renew %s: lost: %v", r.name, err)
+			close(r.lost)
+			return
+		default:
+			log.Printf("renew %s: %v", r.name, err)
+		}
+	}
+}
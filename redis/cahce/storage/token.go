@@ -0,0 +1,16 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// NewToken returns 16 random bytes in hex, for lock tokens, lease and
+// message ids.
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import "time"
+
+type (
+	// TTLPolicy decides how long cached entries stay fresh. Jitter and
+	// Sliding configure the cache the policy is used with, see
+	// cache.Options.
+	TTLPolicy struct {
+		TTL     time.Duration `yaml:"ttl"`
+		Jitter  time.Duration `yaml:"jitter"`
+		Sliding bool          `yaml:"sliding"`
+	}
+
+	// TTLConfig holds the default policy and overrides keyed by route
+	// pattern, e.g. "/card/{id}", or entity name, e.g. "card".
+	TTLConfig struct {
+		Default   TTLPolicy            `yaml:"default"`
+		Overrides map[string]TTLPolicy `yaml:"overrides"`
+	}
+)
+
+// For returns the override of the first name that has one, falling back
+// to the default policy.
+func (c TTLConfig) For(names ...string) TTLPolicy {
+	for _, name := range names {
+		if p, ok := c.Overrides[name]; ok {
+			return p
+		}
+	}
+	return c.Default
+}
+
+// Or returns p, or def when p has no TTL.
+func (p TTLPolicy) Or(def TTLPolicy) TTLPolicy {
+	if p.TTL <= 0 {
+		return def
+	}
+	return p
+}
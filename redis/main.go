@@ -4,12 +4,20 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"my-go-app/redis/cahce/pubsub"
+	"my-go-app/redis/cahce/ratelimit"
+
 	"github.com/go-redis/redis/v8"
 )
 
 type (
+	note struct {
+		Text string `json:"text"`
+	}
+
 	Config struct {
 		Addr        string        `yaml:"addr"`
 		Password    string        `yaml:"password"`
@@ -71,33 +79,68 @@ func main() {
 	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
-	pubsub := rdb.Subscribe(ctxWithTimeout, "cannal") // pub/sub
-	defer pubsub.Close()
+	bus := pubsub.New(rdb, pubsub.Config{}) // pub/sub
+	defer bus.Close()
 
-	rdb.Publish(ctxWithTimeout, "cannal", "massage") // publish
+	received := make(chan struct{}, 1)
+	_, err = pubsub.Subscribe(bus, "cannal", func(ctx context.Context, msg pubsub.Message[note]) error {
+		fmt.Println(msg.Channel, msg.Data.Text) // subscribe
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		return nil
+	}, pubsub.Options{Buffer: 10, Overflow: pubsub.DropOldest})
+	if err != nil {
+		log.Fatal(err)
+	}
+	go bus.Run(ctxWithTimeout)
 
-	ch := pubsub.Channel() // subscribe
+	// messages published before the subscription is active are lost
 	for {
+		if err := bus.Publish(ctxWithTimeout, "cannal", note{Text: "massage"}); err != nil { // publish
+			log.Println(err)
+		}
+
 		select {
+		case <-received:
+			return
 		case <-ctxWithTimeout.Done():
 			return
-		case msg := <-ch:
-			fmt.Println(msg.Channel, msg.Payload)
+		case <-time.After(100 * time.Millisecond):
 		}
-
 	}
 }
 
-func IsRateLimit(ctx context.Context, rdb redis.Client, ip string) (bool, error) { // rate limit (anti DDoS)
-	key := "rate:" + ip
-	count, err := rdb.Incr(ctx, key).Result()
+// ipLimiters holds the limiter IsRateLimit built for each client.
+var ipLimiters sync.Map // redis.UniversalClient -> *ratelimit.Limiter
+
+// IsRateLimit reports whether ip made more than 100 requests in the last
+// hour. Use the ratelimit package directly for other limits or algorithms.
+func IsRateLimit(ctx context.Context, rdb redis.UniversalClient, ip string) (bool, error) { // rate limit (anti DDoS)
+	limiter, err := ipLimiter(rdb)
 	if err != nil {
-		log.Fatal(err)
+		return false, err
 	}
 
-	if count == 1 {
-		rdb.Expire(ctx, key, time.Hour)
+	res, err := limiter.Allow(ctx, ip)
+	if err != nil {
+		return false, err
 	}
+	return !res.Allowed, nil
+}
 
-	return count > 100, nil
+func ipLimiter(rdb redis.UniversalClient) (*ratelimit.Limiter, error) {
+	if limiter, ok := ipLimiters.Load(rdb); ok {
+		return limiter.(*ratelimit.Limiter), nil
+	}
+	limiter, err := ratelimit.New(rdb, ratelimit.Config{
+		Algorithm: ratelimit.SlidingWindow,
+		Default:   ratelimit.PerHour(100),
+	})
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := ipLimiters.LoadOrStore(rdb, limiter)
+	return actual.(*ratelimit.Limiter), nil
 }